@@ -2,132 +2,142 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	awssdk "github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 
+	"github.com/mosajjal/whatthehec/pkg/config"
 	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/invocation"
+	"github.com/mosajjal/whatthehec/pkg/logging"
+	"github.com/mosajjal/whatthehec/pkg/metrics"
 	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/processor"
 	"github.com/mosajjal/whatthehec/pkg/provider/aws"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+	awssecret "github.com/mosajjal/whatthehec/pkg/secret/aws"
 	"github.com/mosajjal/whatthehec/pkg/storage"
 	s3storage "github.com/mosajjal/whatthehec/pkg/storage/s3"
+	"github.com/mosajjal/whatthehec/pkg/tracing"
 )
 
 var (
-	hecClient   *hec.Client
+	cfg         config.Config
+	hecClient   hec.Sender
+	pipeline    processor.Chain
 	awsProvider *aws.Provider
 	awsConfig   awssdk.Config
+	emf         *metrics.EMF
+	tracer      *tracing.Provider
 )
 
-func init() {
+// setup loads the configuration and creates the HEC client, pipeline and
+// provider used by every invocation
+func setup() {
+	// Load and validate configuration
+	cfg = config.Default()
+	cfg.HEC.Source = "aws-lambda"
+	cfg.HEC.SourceType = "aws:cloudwatch"
+	cfg.HEC.Host = "lambda"
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logging.Fatal("Invalid configuration", "error", err)
+	}
+	// The level was validated by Load
+	logging.Setup(cfg.Log.Level)
+
 	var err error
 
 	// Load AWS config
-	region := getEnv("AWS_REGION", "us-east-1")
-	if getEnv("S3_ACCESS_KEY_ID", "") != "" && getEnv("S3_ACCESS_KEY_SECRET", "") != "" {
-		awsConfig, err = config.LoadDefaultConfig(
+	if cfg.FailureStorage.AccessKeyID != "" && cfg.FailureStorage.AccessKeySecret != "" {
+		awsConfig, err = awsconfig.LoadDefaultConfig(
 			context.TODO(),
-			config.WithRegion(region),
-			config.WithCredentialsProvider(
+			awsconfig.WithRegion(cfg.Region),
+			awsconfig.WithCredentialsProvider(
 				credentials.NewStaticCredentialsProvider(
-					getEnv("S3_ACCESS_KEY_ID", ""),
-					getEnv("S3_ACCESS_KEY_SECRET", ""),
+					cfg.FailureStorage.AccessKeyID,
+					cfg.FailureStorage.AccessKeySecret,
 					"",
 				),
 			),
 		)
 	} else {
-		awsConfig, err = config.LoadDefaultConfig(
+		awsConfig, err = awsconfig.LoadDefaultConfig(
 			context.TODO(),
-			config.WithRegion(region),
+			awsconfig.WithRegion(cfg.Region),
 		)
 	}
 	if err != nil {
-		log.Fatalf("Unable to load AWS config: %v", err)
+		logging.Fatal("Unable to load AWS config", "error", err)
 	}
 
-	// Get HEC token (potentially from Secrets Manager)
-	hecToken := getEnv("HEC_TOKEN", "")
-	if strings.HasPrefix(hecToken, "arn:aws:secretsmanager:") {
-		log.Println("Fetching HEC token from AWS Secrets Manager")
-		secretMgr := secretsmanager.NewFromConfig(awsConfig)
-		secret, err := secretMgr.GetSecretValue(context.Background(), &secretsmanager.GetSecretValueInput{
-			SecretId: awssdk.String(hecToken),
-		})
-		if err != nil {
-			log.Fatalf("Failed to get secret from Secrets Manager: %v", err)
-		}
-		hecToken = *secret.SecretString
-	}
-
-	// Parse HEC endpoints
-	endpoints := strings.Split(getEnv("HEC_ENDPOINTS", ""), ",")
-	if len(endpoints) == 0 || endpoints[0] == "" {
-		log.Fatal("HEC_ENDPOINTS is required")
-	}
-
-	// Configure HEC client
-	hecConfig := hec.Config{
-		Endpoints:        endpoints,
-		TLSSkipVerify:    getEnvBool("HEC_TLS_SKIP_VERIFY", true),
-		Proxy:            getEnv("HEC_PROXY", ""),
-		Token:            hecToken,
-		ChannelID:        getEnv("HEC_CHANNEL_ID", ""),
-		Index:            getEnv("HEC_INDEX", "main"),
-		Source:           getEnv("HEC_SOURCE", "aws-lambda"),
-		SourceType:       getEnv("HEC_SOURCETYPE", "aws:cloudwatch"),
-		Host:             getEnv("HEC_HOST", "lambda"),
-		BatchSize:        1,
-		BatchTimeout:     parseDuration(getEnv("HEC_BATCH_TIMEOUT", "2s")),
-		BalanceStrategy:  getEnv("HEC_BALANCE", "roundrobin"),
-		ExtractLogEvents: getEnvBool("HEC_EXTRACT_LOG_EVENTS", false),
-	}
-
-	// Setup storage backends
-	var failureStorage, coldStorage storage.StorageBackend
-
-	if s3URL := getEnv("S3_URL", ""); s3URL != "" {
-		storageConfig := storage.StorageConfig{
-			Provider: "s3",
-			URL:      s3URL,
-		}
-		failureStorage, err = s3storage.NewStorage(storageConfig, awsConfig)
-		if err != nil {
-			log.Printf("Failed to setup failure storage: %v", err)
-		}
+	// HEC tokens may reference Secrets Manager, SSM, a file or env var
+	secrets := secret.NewRegistry()
+	awssecret.Register(secrets, awsConfig)
+
+	// Create HEC client, with failure and cold storage in S3
+	hecClient, err = cfg.NewSender(context.Background(), secrets, newS3Storage)
+	if err != nil {
+		logging.Fatal("Failed to create HEC client", "error", err)
 	}
 
-	if s3ColdURL := getEnv("S3_COLD_STORAGE_URL", ""); s3ColdURL != "" {
-		storageConfig := storage.StorageConfig{
-			Provider: "s3",
-			URL:      s3ColdURL,
-		}
-		coldStorage, err = s3storage.NewStorage(storageConfig, awsConfig)
-		if err != nil {
-			log.Printf("Failed to setup cold storage: %v", err)
-		}
+	pipeline, err = cfg.NewPipeline(context.Background(), secrets)
+	if err != nil {
+		logging.Fatal("Failed to create processing pipeline", "error", err)
 	}
 
-	// Create HEC client
-	hecClient, err = hec.NewClient(hecConfig, failureStorage, coldStorage)
+	tracer, err = tracing.Setup(context.Background(), cfg.Tracing.Tracing())
 	if err != nil {
-		log.Fatalf("Failed to create HEC client: %v", err)
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+
+	multiline, err := cfg.HEC.NewMultiline()
+	if err != nil {
+		logging.Fatal("Invalid multiline rules", "error", err)
+	}
+
+	// Never forward the function's own logs back to itself
+	loopGuard, err := cfg.HEC.NewLoopGuard(os.Getenv("AWS_LAMBDA_LOG_GROUP_NAME"))
+	if err != nil {
+		logging.Fatal("Invalid loop protection rules", "error", err)
 	}
 
 	// Create AWS provider
-	awsProvider = aws.NewProvider(hecConfig.ExtractLogEvents).(*aws.Provider)
+	awsProvider = aws.NewProviderWithOptions(aws.Options{
+		ExtractLogEvents: cfg.HEC.ExtractLogEvents,
+		EmbedMessages:    cfg.HEC.EmbedMessages,
+		MessageOnly:      cfg.HEC.MessageOnly,
+		PlatformLogs:     cfg.HEC.PlatformLogs,
+		Multiline:        multiline,
+		LoopGuard:        loopGuard,
+	}).(*aws.Provider)
+
+	// There is no scrape endpoint in Lambda, so metrics are written to the
+	// log in Embedded Metric Format
+	if cfg.Metrics.EMF {
+		dimensions := map[string]string{}
+		if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+			dimensions["FunctionName"] = name
+		}
+		emf = metrics.NewEMF(metrics.Default, cfg.Metrics.Namespace, dimensions, os.Stdout)
+	}
 
-	log.Println("AWS Lambda handler initialized successfully")
+	slog.Info("AWS Lambda handler initialized successfully")
 }
 
 func HandleRequest(ctx context.Context, event interface{}) (string, error) {
+	defer writeMetrics()
+	ctx, span := tracing.StartInvocation(tracing.LambdaContext(ctx), "aws-lambda")
+	defer invocation.End(span, tracer)
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = logging.WithInvocation(ctx, lc.AwsRequestID)
+	}
+
 	// Parse events using AWS provider
 	cloudEvents, err := awsProvider.ParseBatch(ctx, event)
 	if err != nil {
@@ -137,49 +147,108 @@ func HandleRequest(ctx context.Context, event interface{}) (string, error) {
 	// Convert to HEC events
 	hecEvents := make([]*models.Event, 0, len(cloudEvents))
 	for _, cloudEvent := range cloudEvents {
+		var payload interface{} = string(cloudEvent.RawData)
+		if cloudEvent.Payload != nil {
+			payload = cloudEvent.Payload
+		}
+		eventTime := time.Now()
+		if cfg.HEC.MessageOnly && cloudEvent.Timestamp > 0 {
+			eventTime = time.UnixMilli(cloudEvent.Timestamp)
+		}
+		metadata := map[string]string{
+			"loggroup":  cloudEvent.LogGroup,
+			"logstream": cloudEvent.LogStream,
+		}
+		for k, v := range cloudEvent.Metadata {
+			metadata[k] = v
+		}
+		sourceType := cfg.HEC.SourceType
+		if st := cloudEvent.Metadata["sourcetype"]; st != "" {
+			sourceType = st
+		}
 		hecEvents = append(hecEvents, &models.Event{
-			Time:       time.Now(),
-			Host:       getEnv("HEC_HOST", "lambda"),
-			Source:     getEnv("HEC_SOURCE", "aws-lambda"),
-			SourceType: getEnv("HEC_SOURCETYPE", "aws:cloudwatch"),
-			Index:      getEnv("HEC_INDEX", "main"),
-			Event:      string(cloudEvent.RawData),
+			Time:       eventTime,
+			Host:       cfg.HEC.Host,
+			Source:     cfg.HEC.Source,
+			SourceType: sourceType,
+			Index:      cfg.HEC.Index,
+			Event:      payload,
+			Metadata:   metadata,
 		})
 	}
 
+	// Filter and transform before delivery
+	hecEvents, err = invocation.Process(ctx, pipeline, hecEvents)
+	if err != nil {
+		return "", err
+	}
+	if len(hecEvents) == 0 {
+		return "OK", nil
+	}
+
 	// Send to HEC
-	if err := hecClient.SendEvents(ctx, hecEvents); err != nil {
-		log.Printf("Failed to send events to HEC: %v", err)
+	result := hecClient.Send(ctx, hecEvents)
+
+	// Buffered storage must be written before the runtime freezes the
+	// process. A frozen environment can be reclaimed without the shutdown
+	// event, and the event leaves too little time to upload, so events are
+	// never held across invocations and each invocation that stores events
+	// writes its own objects. Events the upload lost fail the invocation.
+	invocation.Flush(ctx, hecClient, result)
+
+	if err := result.Err(); err != nil {
+		invocation.LogDropped(ctx, result, err)
 		return "", err
 	}
 
-	log.Printf("Successfully processed %d events", len(hecEvents))
+	slog.DebugContext(ctx, "Processed events", "count", len(hecEvents), "stored", result.Count(hec.Stored))
 	return "OK", nil
 }
 
-func main() {
-	lambda.Start(HandleRequest)
+// writeMetrics writes the metrics of this invocation as EMF log lines
+func writeMetrics() {
+	if emf == nil {
+		return
+	}
+	if err := emf.Write(); err != nil {
+		slog.Error("Failed to write metrics", "error", err)
+	}
 }
 
-// Helper functions
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// lambdaShutdownLimit is about what Lambda leaves the runtime after
+// SIGTERM when only internal extensions are registered
+const lambdaShutdownLimit = 450 * time.Millisecond
+
+// shutdown delivers or stores what the HEC client still holds when Lambda
+// shuts the execution environment down, and reports what was lost
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), min(cfg.ShutdownTimeout, lambdaShutdownLimit))
+	defer cancel()
+	if err := hecClient.Shutdown(ctx); err != nil {
+		slog.Error("Events not delivered at shutdown", "error", err)
 	}
-	return defaultValue
+	if err := tracer.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to export traces", "error", err)
+	}
+	slog.Info("Lambda handler shut down")
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return strings.ToLower(value) == "true"
-	}
-	return defaultValue
+func main() {
+	setup()
+
+	// Registering for SIGTERM subscribes an internal extension to the
+	// shutdown event
+	lambda.StartWithOptions(HandleRequest, lambda.WithEnableSIGTERM(shutdown))
 }
 
-func parseDuration(s string) time.Duration {
-	d, err := time.ParseDuration(s)
+// newS3Storage creates an S3 backend, wrapped in a buffer when enabled
+func newS3Storage(storageCfg config.StorageConfig) (storage.StorageBackend, error) {
+	backend, err := s3storage.NewStorage(storageCfg.StorageConfig(), awsConfig)
 	if err != nil {
-		return 2 * time.Second
+		return nil, err
+	}
+	if !storageCfg.Buffer {
+		return backend, nil
 	}
-	return d
+	return storage.NewBufferedStorage(backend, storageCfg.BufferConfig()), nil
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/provider/aws"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+// slowBackend records stored events after a delay, like an S3 upload
+type slowBackend struct {
+	mu     sync.Mutex
+	stored int
+	err    error
+}
+
+func (s *slowBackend) Store(ctx context.Context, events []*models.Event) error {
+	time.Sleep(50 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.stored += len(events)
+	return nil
+}
+
+func (s *slowBackend) Close() error { return nil }
+
+func (s *slowBackend) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stored
+}
+
+// setupBusyHEC points the handler at a HEC that rejects every send, with
+// failure storage buffered in front of backend
+func setupBusyHEC(t *testing.T, backend storage.StorageBackend) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	t.Cleanup(server.Close)
+
+	// Limits that are never reached, so only a flush uploads the events
+	failure := storage.NewBufferedStorage(backend, storage.BufferConfig{MaxEvents: 100, MaxAge: time.Hour})
+	client, err := hec.NewClient(hec.Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	hecClient = client
+	awsProvider = aws.NewProviderWithOptions(aws.Options{ExtractLogEvents: true}).(*aws.Provider)
+}
+
+func TestHandleRequest_FlushesStorage(t *testing.T) {
+	backend := &slowBackend{}
+	setupBusyHEC(t, backend)
+
+	if _, err := HandleRequest(context.Background(), cloudWatchEvent(t, "first", "second")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := backend.count(); got != 2 {
+		t.Errorf("Expected 2 events uploaded before the invocation returned, got %d", got)
+	}
+}
+
+func TestHandleRequest_FlushFailure(t *testing.T) {
+	setupBusyHEC(t, &slowBackend{err: errors.New("upload failed")})
+
+	// The events were neither delivered nor written, so Lambda must retry
+	_, err := HandleRequest(context.Background(), cloudWatchEvent(t, "first"))
+	if !errors.Is(err, hec.ErrNotStored) {
+		t.Errorf("Expected the failed upload to fail the invocation, got %v", err)
+	}
+}
+
+// cloudWatchEvent encodes log messages as a CloudWatch Logs subscription event
+func cloudWatchEvent(t *testing.T, messages ...string) map[string]interface{} {
+	data := aws.CloudWatchLogsData{MessageType: "DATA_MESSAGE", LogGroup: "/aws/lambda/app", LogStream: "stream"}
+	for i, message := range messages {
+		data.LogEvents = append(data.LogEvents, aws.LogEvent{ID: "id", Timestamp: int64(1700000000000 + i), Message: message})
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(raw)
+	gz.Close()
+	return map[string]interface{}{
+		"awslogs": map[string]interface{}{"data": base64.StdEncoding.EncodeToString(buf.Bytes())},
+	}
+}
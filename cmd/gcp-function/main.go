@@ -2,57 +2,79 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/mosajjal/whatthehec/pkg/config"
 	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/invocation"
+	"github.com/mosajjal/whatthehec/pkg/logging"
 	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/processor"
 	"github.com/mosajjal/whatthehec/pkg/provider/gcp"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+	gcpsecret "github.com/mosajjal/whatthehec/pkg/secret/gcp"
+	"github.com/mosajjal/whatthehec/pkg/server"
+	"github.com/mosajjal/whatthehec/pkg/tracing"
 )
 
 var (
-	hecClient   *hec.Client
+	cfg         config.Config
+	hecClient   hec.Sender
+	pipeline    processor.Chain
 	gcpProvider *gcp.Provider
+	tracer      *tracing.Provider
 )
 
 func init() {
-	// Parse HEC endpoints
-	endpoints := strings.Split(getEnv("HEC_ENDPOINTS", ""), ",")
-	if len(endpoints) == 0 || endpoints[0] == "" {
-		log.Fatal("HEC_ENDPOINTS is required")
+	// Load and validate configuration
+	cfg = config.Default()
+	cfg.HEC.Source = "gcp-function"
+	cfg.HEC.SourceType = "gcp:logging"
+	cfg.HEC.Host = "gcp-function"
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logging.Fatal("Invalid configuration", "error", err)
 	}
+	// The level was validated by Load
+	logging.Setup(cfg.Log.Level)
 
-	// Configure HEC client
-	hecConfig := hec.Config{
-		Endpoints:        endpoints,
-		TLSSkipVerify:    getEnvBool("HEC_TLS_SKIP_VERIFY", true),
-		Proxy:            getEnv("HEC_PROXY", ""),
-		Token:            getEnv("HEC_TOKEN", ""),
-		ChannelID:        getEnv("HEC_CHANNEL_ID", ""),
-		Index:            getEnv("HEC_INDEX", "main"),
-		Source:           getEnv("HEC_SOURCE", "gcp-function"),
-		SourceType:       getEnv("HEC_SOURCETYPE", "gcp:logging"),
-		Host:             getEnv("HEC_HOST", "gcp-function"),
-		BatchSize:        1,
-		BatchTimeout:     parseDuration(getEnv("HEC_BATCH_TIMEOUT", "2s")),
-		BalanceStrategy:  getEnv("HEC_BALANCE", "roundrobin"),
-		ExtractLogEvents: getEnvBool("HEC_EXTRACT_LOG_EVENTS", false),
-	}
+	// HEC tokens may reference Secret Manager, a file or env var
+	secrets := secret.NewRegistry()
+	gcpsecret.Register(secrets)
 
 	var err error
-	hecClient, err = hec.NewClient(hecConfig, nil, nil)
+	hecClient, err = cfg.NewSender(context.Background(), secrets, nil)
+	if err != nil {
+		logging.Fatal("Failed to create HEC client", "error", err)
+	}
+
+	pipeline, err = cfg.NewPipeline(context.Background(), secrets)
 	if err != nil {
-		log.Fatalf("Failed to create HEC client: %v", err)
+		logging.Fatal("Failed to create processing pipeline", "error", err)
 	}
 
-	gcpProvider = gcp.NewProvider(hecConfig.ExtractLogEvents).(*gcp.Provider)
-	log.Println("GCP Function handler initialized successfully")
+	tracer, err = tracing.Setup(context.Background(), cfg.Tracing.Tracing())
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+
+	gcpProvider = gcp.NewProvider(cfg.HEC.ExtractLogEvents).(*gcp.Provider)
+	slog.Info("GCP Function handler initialized successfully")
 }
 
 // HandleRequest processes GCP Cloud Logging events
 func HandleRequest(ctx context.Context, event interface{}) (string, error) {
+	ctx, span := tracing.StartInvocation(tracing.EventContext(ctx, event), "gcp-function")
+	defer invocation.End(span, tracer)
+	// The host does not pass an invocation ID to the handler
+	ctx = logging.WithInvocation(ctx, uuid.New().String())
+
 	cloudEvents, err := gcpProvider.ParseBatch(ctx, event)
 	if err != nil {
 		return "", err
@@ -62,46 +84,64 @@ func HandleRequest(ctx context.Context, event interface{}) (string, error) {
 	for _, cloudEvent := range cloudEvents {
 		hecEvents = append(hecEvents, &models.Event{
 			Time:       time.Now(),
-			Host:       getEnv("HEC_HOST", "gcp-function"),
-			Source:     getEnv("HEC_SOURCE", "gcp-function"),
-			SourceType: getEnv("HEC_SOURCETYPE", "gcp:logging"),
-			Index:      getEnv("HEC_INDEX", "main"),
+			Host:       cfg.HEC.Host,
+			Source:     cfg.HEC.Source,
+			SourceType: cfg.HEC.SourceType,
+			Index:      cfg.HEC.Index,
 			Event:      string(cloudEvent.RawData),
 		})
 	}
 
-	if err := hecClient.SendEvents(ctx, hecEvents); err != nil {
-		log.Printf("Failed to send events to HEC: %v", err)
+	hecEvents, err = invocation.Process(ctx, pipeline, hecEvents)
+	if err != nil {
+		return "", err
+	}
+	if len(hecEvents) == 0 {
+		return "OK", nil
+	}
+
+	result := hecClient.Send(ctx, hecEvents)
+	if err := result.Err(); err != nil {
+		invocation.LogDropped(ctx, result, err)
 		return "", err
 	}
 
-	log.Printf("Successfully processed %d events", len(hecEvents))
+	slog.DebugContext(ctx, "Processed events", "count", len(hecEvents), "stored", result.Count(hec.Stored))
 	return "OK", nil
 }
 
-func main() {
-	// GCP Functions runtime will call HandleRequest
-	log.Println("GCP Function for Splunk HEC ready")
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// handleInvocation serves one event delivered over HTTP, such as a
+// CloudEvent from Eventarc or a Pub/Sub push, with its data as the body.
+// An error status makes the sender retry.
+func handleInvocation(w http.ResponseWriter, r *http.Request) {
+	ctx, event, err := server.Decode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	return defaultValue
-}
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return strings.ToLower(value) == "true"
+	result, err := HandleRequest(ctx, event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	return defaultValue
+	w.Write([]byte(result))
 }
 
-func parseDuration(s string) time.Duration {
-	d, err := time.ParseDuration(s)
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	err := server.Run(ctx, server.Config{
+		Name:            "GCP Function",
+		Addr:            server.Addr("PORT"),
+		Handler:         http.HandlerFunc(handleInvocation),
+		MetricsAddr:     cfg.Metrics.Addr,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		Sender:          hecClient,
+		Tracer:          tracer,
+	})
 	if err != nil {
-		return 2 * time.Second
+		logging.Fatal("GCP Function stopped", "error", err)
 	}
-	return d
 }
@@ -34,12 +34,13 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/mosajjal/Go-Splunk-HTTP/splunk/v2"
+	s3storage "github.com/mosajjal/whatthehec/pkg/storage/s3"
 )
 
 var args struct {
 	Region                       string        `arg:"env:AWS_REGION" default:"ap-southeast-2"`
 	Endpoints                    []string      `arg:"env:HEC_ENDPOINTS,required"`
-	TLSSkipVerify                bool          `arg:"env:HEC_TLS_SKIP_VERIFY" default:"true"`
+	TLSSkipVerify                bool          `arg:"env:HEC_TLS_SKIP_VERIFY" default:"false"`
 	Proxy                        string        `arg:"env:HEC_PROXY"`
 	Token                        string        `arg:"env:HEC_TOKEN"`
 	ChannelID                    string        `arg:"env:HEC_CHANNEL_ID"`
@@ -230,7 +231,7 @@ func (s3Bucket *S3) Send(events ...*splunk.Event) error {
 		log.Printf("error parsing S3 URL: %v", err)
 		return err
 	}
-	AmazonS3URL := ParseAmazonS3URL(u)
+	AmazonS3URL := s3storage.ParseAmazonS3URL(u)
 
 	client := s3.NewFromConfig(awsCfg)
 
@@ -0,0 +1,745 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/logging"
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/processor"
+	awsprovider "github.com/mosajjal/whatthehec/pkg/provider/aws"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+	s3storage "github.com/mosajjal/whatthehec/pkg/storage/s3"
+	"github.com/mosajjal/whatthehec/pkg/tracing"
+)
+
+// Config holds the settings shared by every cmd/ entry point. Fields are
+// loaded from a YAML or JSON file, then environment variables, then flags.
+type Config struct {
+	File   string `env:"WHATTHEHEC_CONFIG" yaml:"-" help:"path to a YAML or JSON config file"`
+	Region string `env:"AWS_REGION" yaml:"region" help:"AWS region"`
+
+	HEC            HECConfig     `env:"HEC_" yaml:"hec"`
+	FailureStorage StorageConfig `env:"S3_" yaml:"failure_storage"`
+	ColdStorage    StorageConfig `env:"S3_COLD_STORAGE_" yaml:"cold_storage"`
+	Metrics        MetricsConfig `env:"METRICS_" yaml:"metrics"`
+	Tracing        TracingConfig `env:"TRACING_" yaml:"tracing"`
+	Log            LogConfig     `env:"LOG_" yaml:"log"`
+
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout" help:"time to deliver or store pending events when the function is stopped"`
+
+	// Destinations enables fan-out to several HEC deployments and can only
+	// be set in the config file. Unset fields inherit from HEC, except
+	// endpoints and tokens.
+	FanOutMode   string              `env:"HEC_FANOUT_MODE" yaml:"fanout_mode" help:"all or best_effort"`
+	Destinations []DestinationConfig `yaml:"destinations"`
+	// destinationKeys holds the keys set for each destination in the
+	// config file, so that booleans left unset can be inherited
+	destinationKeys []map[string]interface{}
+
+	// Processing runs between provider parsing and delivery and can only be
+	// set in the config file
+	Processing ProcessingConfig `yaml:"processing"`
+}
+
+// MetricsConfig holds metrics export settings
+type MetricsConfig struct {
+	Addr      string `env:"ADDR" yaml:"addr" help:"listen address for the Prometheus /metrics endpoint, e.g. :9090"`
+	EMF       bool   `env:"EMF" yaml:"emf" help:"write CloudWatch Embedded Metric Format lines after each Lambda invocation"`
+	Namespace string `env:"NAMESPACE" yaml:"namespace" help:"CloudWatch namespace for EMF metrics"`
+}
+
+// TracingConfig holds OpenTelemetry tracing settings; see tracing.Config
+type TracingConfig struct {
+	Endpoint    string            `env:"ENDPOINT" yaml:"endpoint" help:"OTLP/HTTP collector URL, e.g. http://localhost:4318; defaults to OTEL_EXPORTER_OTLP_ENDPOINT"`
+	Headers     map[string]string `env:"HEADERS" yaml:"headers" help:"OTLP export headers as key=value,key=value"`
+	ServiceName string            `env:"SERVICE_NAME" yaml:"service_name" help:"service.name of exported spans"`
+	SampleRatio float64           `env:"SAMPLE_RATIO" yaml:"sample_ratio" help:"share of traces sampled without a sampled parent, 0 to 1"`
+}
+
+// Tracing returns the settings for tracing.Setup
+func (t TracingConfig) Tracing() tracing.Config {
+	return tracing.Config{
+		Endpoint:    t.Endpoint,
+		Headers:     t.Headers,
+		ServiceName: t.ServiceName,
+		SampleRatio: t.SampleRatio,
+	}
+}
+
+// LogConfig holds logging settings
+type LogConfig struct {
+	Level string `env:"LEVEL" yaml:"level" help:"minimum log level: debug, info, warn or error"`
+}
+
+// ProcessingConfig holds the event processing stages
+type ProcessingConfig struct {
+	Parsers []ParserConfig `yaml:"parsers"`
+	Filters []FilterConfig `yaml:"filters"`
+	Redact  RedactConfig   `yaml:"redact"`
+}
+
+// ParserConfig holds one parser route, which runs before filtering; see
+// processor.ParseRoute
+type ParserConfig struct {
+	Name       string            `yaml:"name"`
+	Match      map[string]string `yaml:"match"`
+	Format     string            `yaml:"format"`
+	SourceType string            `yaml:"sourcetype"`
+	Fields     []string          `yaml:"fields"`
+}
+
+// RedactConfig holds the redaction stage, which runs after filtering; see
+// processor.RedactConfig
+type RedactConfig struct {
+	Builtin    []string           `yaml:"builtin"`
+	Rules      []RedactRuleConfig `yaml:"rules"`
+	DeleteKeys []string           `yaml:"delete_keys"`
+	HashKeys   []string           `yaml:"hash_keys"`
+	// HMACKey may be a secret reference, like the HEC token
+	HMACKey string `yaml:"hmac_key"`
+}
+
+// RedactRuleConfig holds one custom redaction regex
+type RedactRuleConfig struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+func (r *RedactConfig) enabled() bool {
+	return len(r.Builtin) > 0 || len(r.Rules) > 0 || len(r.DeleteKeys) > 0 || len(r.HashKeys) > 0
+}
+
+// FilterConfig holds one filter route; see processor.FilterRoute
+type FilterConfig struct {
+	Name          string            `yaml:"name"`
+	Match         map[string]string `yaml:"match"`
+	Include       []string          `yaml:"include"`
+	Exclude       []string          `yaml:"exclude"`
+	IncludeFields map[string]string `yaml:"include_fields"`
+	ExcludeFields map[string]string `yaml:"exclude_fields"`
+	SampleRate    *float64          `yaml:"sample_rate"`
+	SampleKey     string            `yaml:"sample_key"`
+}
+
+// DestinationConfig holds one fan-out destination
+type DestinationConfig struct {
+	Name           string        `yaml:"name"`
+	HEC            HECConfig     `yaml:"hec"`
+	FailureStorage StorageConfig `yaml:"failure_storage"`
+	// Filter maps event fields (index, sourcetype, source, host or metadata
+	// such as loggroup) to regular expressions that must all match
+	Filter map[string]string `yaml:"filter"`
+}
+
+// HECConfig holds HEC delivery settings
+type HECConfig struct {
+	Endpoints        []string        `env:"ENDPOINTS" yaml:"endpoints" help:"comma-separated list of HEC URLs"`
+	Token            string          `env:"TOKEN" yaml:"token" help:"HEC token or secret reference"`
+	TokenRefresh     time.Duration   `env:"TOKEN_REFRESH" yaml:"token_refresh" help:"how often to re-read a secret token, 0 disables"`
+	TLSSkipVerify    bool            `env:"TLS_SKIP_VERIFY" yaml:"tls_skip_verify" help:"skip TLS certificate verification"`
+	TLS              TLSConfig       `env:"TLS_" yaml:"tls"`
+	Proxy            string          `env:"PROXY" yaml:"proxy" help:"proxy URL"`
+	ChannelID        string          `env:"CHANNEL_ID" yaml:"channel_id" help:"HEC channel ID (UUID)"`
+	Index            string          `env:"INDEX" yaml:"index" help:"target Splunk index"`
+	Source           string          `env:"SOURCE" yaml:"source" help:"event source"`
+	SourceType       string          `env:"SOURCETYPE" yaml:"sourcetype" help:"event sourcetype"`
+	Host             string          `env:"HOST" yaml:"host" help:"event host"`
+	BatchSize        int             `env:"BATCH_SIZE" yaml:"batch_size" help:"events per HEC request"`
+	BatchTimeout     time.Duration   `env:"BATCH_TIMEOUT" yaml:"batch_timeout" help:"HEC request timeout"`
+	Balance          string          `env:"BALANCE" yaml:"balance" help:"first_available, sticky, random or roundrobin"`
+	StickyTTL        time.Duration   `env:"STICKY_TTL" yaml:"sticky_ttl" help:"how long sticky stays on one endpoint"`
+	HealthInterval   time.Duration   `env:"HEALTH_CHECK_INTERVAL" yaml:"health_check_interval" help:"how often endpoints are probed"`
+	FailureThreshold int             `env:"FAILURE_THRESHOLD" yaml:"failure_threshold" help:"consecutive failed sends that open an endpoint's circuit, 0 disables"`
+	CircuitCooldown  time.Duration   `env:"CIRCUIT_COOLDOWN" yaml:"circuit_cooldown" help:"how long an open circuit skips the endpoint"`
+	ExtractLogEvents bool            `env:"EXTRACT_LOG_EVENTS" yaml:"extract_log_events" help:"send each CloudWatch log event separately"`
+	EmbedMessages    bool            `env:"EMBED_MESSAGES" yaml:"embed_messages" help:"embed JSON and logfmt log messages as objects"`
+	MessageOnly      bool            `env:"MESSAGE_ONLY" yaml:"message_only" help:"send only the log message, timed by its log event"`
+	PlatformLogs     string          `env:"PLATFORM_LOGS" yaml:"platform_logs" help:"Lambda START/END/REPORT lines: keep, drop or metrics"`
+	Multiline        MultilineConfig `env:"MULTILINE_" yaml:"multiline"`
+	Loop             LoopConfig      `env:"LOOP_" yaml:"loop"`
+
+	// EndpointConfigs can only be set in the config file
+	EndpointConfigs []EndpointConfig `yaml:"endpoint_configs"`
+}
+
+// TLSConfig holds TLS settings for HEC connections
+type TLSConfig struct {
+	CAFile     string `env:"CA_FILE" yaml:"ca_file" help:"PEM CA bundle file"`
+	CA         string `env:"CA" yaml:"ca" help:"PEM CA bundle contents"`
+	CertFile   string `env:"CERT_FILE" yaml:"cert_file" help:"client certificate file for mutual TLS"`
+	KeyFile    string `env:"KEY_FILE" yaml:"key_file" help:"client key file for mutual TLS"`
+	MinVersion string `env:"MIN_VERSION" yaml:"min_version" help:"minimum TLS version: 1.0, 1.1, 1.2 or 1.3"`
+	ServerName string `env:"SERVER_NAME" yaml:"server_name" help:"override SNI and certificate name"`
+}
+
+// MultilineConfig holds the rules for joining extracted log events, such as
+// stack traces; see aws.MultilineConfig
+type MultilineConfig struct {
+	Start        string `env:"START" yaml:"start" help:"regex matching the first line of an event"`
+	Continuation string `env:"CONTINUATION" yaml:"continuation" help:"regex matching lines joined to the previous event"`
+	MaxLines     int    `env:"MAX_LINES" yaml:"max_lines" help:"maximum lines per joined event, 0 for no limit"`
+	MaxBytes     int    `env:"MAX_BYTES" yaml:"max_bytes" help:"maximum bytes per joined event, 0 for no limit"`
+}
+
+// NewMultiline compiles the multiline rules, returning nil if they are not
+// set
+func (h *HECConfig) NewMultiline() (*awsprovider.Multiline, error) {
+	return awsprovider.NewMultiline(awsprovider.MultilineConfig{
+		StartPattern:        h.Multiline.Start,
+		ContinuationPattern: h.Multiline.Continuation,
+		MaxLines:            h.Multiline.MaxLines,
+		MaxBytes:            h.Multiline.MaxBytes,
+	})
+}
+
+// LoopConfig holds the self-forwarding protection rules; see
+// aws.LoopGuardConfig
+type LoopConfig struct {
+	DenyLogGroups []string      `env:"DENY_LOG_GROUPS" yaml:"deny_log_groups" help:"comma-separated log groups never forwarded, * suffix for prefixes"`
+	MaxEvents     int           `env:"MAX_EVENTS" yaml:"max_events" help:"events per log group per window before its breaker opens, 0 disables"`
+	Window        time.Duration `env:"WINDOW" yaml:"window" help:"rate window of the breaker"`
+	Cooldown      time.Duration `env:"COOLDOWN" yaml:"cooldown" help:"how long an open breaker drops a log group's events"`
+}
+
+// NewLoopGuard creates the loop guard, denying ownLogGroup, the function's
+// own log group, in addition to the configured log groups. It returns nil
+// if no rule is set.
+func (h *HECConfig) NewLoopGuard(ownLogGroup string) (*awsprovider.LoopGuard, error) {
+	deny := append([]string(nil), h.Loop.DenyLogGroups...)
+	if ownLogGroup != "" {
+		deny = append(deny, ownLogGroup)
+	}
+	return awsprovider.NewLoopGuard(awsprovider.LoopGuardConfig{
+		DenyLogGroups: deny,
+		MaxEvents:     h.Loop.MaxEvents,
+		Window:        h.Loop.Window,
+		Cooldown:      h.Loop.Cooldown,
+	})
+}
+
+// hec converts the TLS settings to a hec.TLSConfig
+func (t TLSConfig) hec() hec.TLSConfig {
+	return hec.TLSConfig{
+		CAFile:     t.CAFile,
+		CAPEM:      t.CA,
+		CertFile:   t.CertFile,
+		KeyFile:    t.KeyFile,
+		MinVersion: t.MinVersion,
+		ServerName: t.ServerName,
+	}
+}
+
+// EndpointConfig holds settings for one HEC endpoint. Empty fields inherit
+// the values from HECConfig.
+type EndpointConfig struct {
+	URL           string        `yaml:"url"`
+	Token         string        `yaml:"token"`
+	TLSSkipVerify *bool         `yaml:"tls_skip_verify"`
+	TLS           TLSConfig     `yaml:"tls"`
+	Proxy         string        `yaml:"proxy"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Weight        int           `yaml:"weight"`
+}
+
+// StorageConfig holds settings for one S3 storage backend
+type StorageConfig struct {
+	URL                  string            `env:"URL" yaml:"url" help:"S3 URL, storage is disabled when empty"`
+	AccessKeyID          string            `env:"ACCESS_KEY_ID" yaml:"access_key_id" help:"S3 access key"`
+	AccessKeySecret      string            `env:"ACCESS_KEY_SECRET" yaml:"access_key_secret" help:"S3 secret key"`
+	Region               string            `env:"REGION" yaml:"region" help:"bucket region"`
+	Format               string            `env:"FORMAT" yaml:"format" help:"raw, hec or parquet"`
+	Compression          string            `env:"COMPRESSION" yaml:"compression" help:"gzip, zstd or none"`
+	KeyTemplate          string            `env:"KEY_TEMPLATE" yaml:"key_template" help:"object key layout"`
+	KeyTimeSource        string            `env:"KEY_TIME_SOURCE" yaml:"key_time_source" help:"arrival or event"`
+	KeyUTC               bool              `env:"KEY_UTC" yaml:"key_utc" help:"render key times in UTC"`
+	Endpoint             string            `env:"ENDPOINT" yaml:"endpoint" help:"custom S3 endpoint"`
+	ForcePathStyle       bool              `env:"FORCE_PATH_STYLE" yaml:"force_path_style" help:"use path-style requests"`
+	ServerSideEncryption string            `env:"SSE" yaml:"sse" help:"AES256, aws:kms or aws:kms:dsse"`
+	KMSKeyID             string            `env:"KMS_KEY_ID" yaml:"kms_key_id" help:"KMS key for aws:kms"`
+	StorageClass         string            `env:"STORAGE_CLASS" yaml:"storage_class" help:"S3 storage class"`
+	Tags                 map[string]string `env:"TAGS" yaml:"tags" help:"object tags as key=value,key=value"`
+	Buffer               bool              `env:"BUFFER" yaml:"buffer" help:"buffer events and upload in the background"`
+	BufferMaxEvents      int               `env:"BUFFER_MAX_EVENTS" yaml:"buffer_max_events" help:"upload after this many events"`
+	BufferMaxBytes       int               `env:"BUFFER_MAX_BYTES" yaml:"buffer_max_bytes" help:"upload after this many bytes"`
+	BufferMaxAge         time.Duration     `env:"BUFFER_MAX_AGE" yaml:"buffer_max_age" help:"upload events older than this"`
+	BufferConcurrency    int               `env:"BUFFER_CONCURRENCY" yaml:"buffer_concurrency" help:"maximum parallel uploads"`
+	BufferMaxRetainBytes int               `env:"BUFFER_MAX_RETAIN_BYTES" yaml:"buffer_max_retain_bytes" help:"bytes kept for retry after failed uploads"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() Config {
+	return Config{
+		Region:     "us-east-1",
+		FanOutMode: hec.FanOutAll,
+		Metrics:    MetricsConfig{Namespace: "whatthehec"},
+		Tracing:    TracingConfig{ServiceName: "whatthehec", SampleRatio: 1},
+		Log:        LogConfig{Level: "info"},
+
+		ShutdownTimeout: 10 * time.Second,
+		HEC: HECConfig{
+			TokenRefresh: 5 * time.Minute,
+			Index:        "main",
+			BatchSize:    1,
+			BatchTimeout: 2 * time.Second,
+			Balance:      "roundrobin",
+			PlatformLogs: "keep",
+			Multiline: MultilineConfig{
+				MaxLines: 500,
+				MaxBytes: 256 * 1024,
+			},
+			Loop: LoopConfig{
+				Window:   time.Minute,
+				Cooldown: 5 * time.Minute,
+			},
+			StickyTTL:        5 * time.Minute,
+			HealthInterval:   hec.DefaultHealthCheckInterval,
+			FailureThreshold: 3,
+			CircuitCooldown:  hec.DefaultCircuitCooldown,
+		},
+		FailureStorage: defaultStorage(),
+		ColdStorage:    defaultStorage(),
+	}
+}
+
+func defaultStorage() StorageConfig {
+	return StorageConfig{
+		Format:            storage.FormatRaw,
+		Compression:       storage.CompressionGzip,
+		KeyTemplate:       storage.KeyTemplateDefault,
+		KeyTimeSource:     storage.KeyTimeArrival,
+		BufferMaxBytes:    8 * 1024 * 1024,
+		BufferConcurrency: 4,
+	}
+}
+
+// HECClientConfig converts the HEC settings to a hec.Config
+func (c *Config) HECClientConfig() hec.Config {
+	return c.HEC.client()
+}
+
+func (h *HECConfig) client() hec.Config {
+	var endpointConfigs []hec.EndpointConfig
+	for _, e := range h.EndpointConfigs {
+		endpointConfigs = append(endpointConfigs, hec.EndpointConfig{
+			URL:           e.URL,
+			Token:         e.Token,
+			TLSSkipVerify: e.TLSSkipVerify,
+			TLS:           e.TLS.hec(),
+			Proxy:         e.Proxy,
+			Timeout:       e.Timeout,
+			Weight:        e.Weight,
+		})
+	}
+
+	return hec.Config{
+		Endpoints:        h.Endpoints,
+		TLSSkipVerify:    h.TLSSkipVerify,
+		TLS:              h.TLS.hec(),
+		Proxy:            h.Proxy,
+		Token:            h.Token,
+		ChannelID:        h.ChannelID,
+		Index:            h.Index,
+		Source:           h.Source,
+		SourceType:       h.SourceType,
+		Host:             h.Host,
+		BatchSize:        h.BatchSize,
+		BatchTimeout:     h.BatchTimeout,
+		BalanceStrategy:  h.Balance,
+		StickyTTL:        h.StickyTTL,
+		ExtractLogEvents: h.ExtractLogEvents,
+		EndpointConfigs:  endpointConfigs,
+
+		HealthCheckInterval: h.HealthInterval,
+		FailureThreshold:    h.FailureThreshold,
+		CircuitCooldown:     h.CircuitCooldown,
+	}
+}
+
+// Enabled reports whether the storage backend is configured
+func (s *StorageConfig) Enabled() bool {
+	return s.URL != ""
+}
+
+// StorageConfig converts the settings to a storage.StorageConfig
+func (s *StorageConfig) StorageConfig() storage.StorageConfig {
+	return storage.StorageConfig{
+		Provider:             "s3",
+		URL:                  s.URL,
+		AccessKey:            s.AccessKeyID,
+		SecretKey:            s.AccessKeySecret,
+		Region:               s.Region,
+		CompressionType:      s.Compression,
+		Format:               s.Format,
+		KeyTemplate:          s.KeyTemplate,
+		KeyTimeSource:        s.KeyTimeSource,
+		KeyUTC:               s.KeyUTC,
+		Endpoint:             s.Endpoint,
+		ForcePathStyle:       s.ForcePathStyle,
+		ServerSideEncryption: s.ServerSideEncryption,
+		KMSKeyID:             s.KMSKeyID,
+		StorageClass:         s.StorageClass,
+		Tags:                 s.Tags,
+	}
+}
+
+// BufferConfig converts the buffer settings to a storage.BufferConfig
+func (s *StorageConfig) BufferConfig() storage.BufferConfig {
+	return storage.BufferConfig{
+		MaxEvents:      s.BufferMaxEvents,
+		MaxBytes:       s.BufferMaxBytes,
+		MaxAge:         s.BufferMaxAge,
+		Concurrency:    s.BufferConcurrency,
+		MaxRetainBytes: s.BufferMaxRetainBytes,
+	}
+}
+
+// Validate checks the configuration and returns every problem found
+func (c *Config) Validate() error {
+	errs := c.HEC.validate(len(c.Destinations) == 0)
+
+	switch c.FanOutMode {
+	case hec.FanOutAll, hec.FanOutBestEffort:
+	default:
+		errs = append(errs, fmt.Errorf("HEC_FANOUT_MODE: unknown mode %q", c.FanOutMode))
+	}
+	names := make(map[string]bool)
+	for i, d := range c.Destinations {
+		if d.Name == "" || names[d.Name] {
+			errs = append(errs, fmt.Errorf("destinations[%d]: name must be set and unique", i))
+		}
+		names[d.Name] = true
+
+		destErrs := d.HEC.validate(true)
+		destErrs = append(destErrs, d.FailureStorage.validate(fileLabel("failure_storage."))...)
+		if _, err := d.filter(); err != nil {
+			destErrs = append(destErrs, err)
+		}
+		for _, err := range destErrs {
+			errs = append(errs, fmt.Errorf("destinations[%d] (%s): %w", i, d.Name, err))
+		}
+	}
+
+	errs = append(errs, c.FailureStorage.validate(envLabel("S3_"))...)
+	errs = append(errs, c.ColdStorage.validate(envLabel("S3_COLD_STORAGE_"))...)
+
+	if c.Tracing.Endpoint != "" {
+		if err := validateURL(c.Tracing.Endpoint, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("TRACING_ENDPOINT: %w", err))
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO: must be between 0 and 1"))
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: must be debug, info, warn or error"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT: must be positive"))
+	}
+
+	if _, err := c.NewPipeline(context.Background(), nil); err != nil {
+		errs = append(errs, fmt.Errorf("processing: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks the HEC settings. Endpoints are optional at the top level
+// when destinations are configured.
+func (h *HECConfig) validate(requireEndpoints bool) []error {
+	var errs []error
+
+	if requireEndpoints && len(h.Endpoints) == 0 && len(h.EndpointConfigs) == 0 {
+		errs = append(errs, fmt.Errorf("HEC_ENDPOINTS is required"))
+	}
+	for _, endpoint := range h.Endpoints {
+		if err := validateURL(endpoint, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("HEC_ENDPOINTS: %w", err))
+		}
+	}
+	if h.Proxy != "" {
+		if err := validateURL(h.Proxy, "http", "https", "socks5", "socks5h"); err != nil {
+			errs = append(errs, fmt.Errorf("HEC_PROXY: %w", err))
+		}
+	}
+	if _, err := h.TLS.hec().ClientConfig(h.TLSSkipVerify); err != nil {
+		errs = append(errs, fmt.Errorf("HEC_TLS_*: %w", err))
+	}
+	for i, e := range h.EndpointConfigs {
+		if err := validateURL(e.URL, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].url: %w", i, err))
+		}
+		if _, err := e.TLS.hec().ClientConfig(false); err != nil {
+			errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].tls: %w", i, err))
+		}
+		if e.Proxy != "" {
+			if err := validateURL(e.Proxy, "http", "https", "socks5", "socks5h"); err != nil {
+				errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].proxy: %w", i, err))
+			}
+		}
+		if e.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].timeout: must not be negative", i))
+		}
+		if e.Weight < 0 {
+			errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].weight: must not be negative", i))
+		}
+	}
+	if h.ChannelID != "" {
+		if _, err := uuid.Parse(h.ChannelID); err != nil {
+			errs = append(errs, fmt.Errorf("HEC_CHANNEL_ID: must be a UUID"))
+		}
+	}
+	switch h.Balance {
+	case "first_available", "sticky", "random", "roundrobin":
+	default:
+		errs = append(errs, fmt.Errorf("HEC_BALANCE: unknown strategy %q", h.Balance))
+	}
+	switch h.PlatformLogs {
+	case "keep", "drop", "metrics":
+	default:
+		errs = append(errs, fmt.Errorf("HEC_PLATFORM_LOGS: must be keep, drop or metrics"))
+	}
+	if _, err := h.NewMultiline(); err != nil {
+		errs = append(errs, fmt.Errorf("HEC_MULTILINE: %w", err))
+	}
+	if _, err := h.NewLoopGuard(""); err != nil {
+		errs = append(errs, fmt.Errorf("HEC_LOOP: %w", err))
+	}
+	if h.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("HEC_BATCH_SIZE: must be at least 1"))
+	}
+	if h.BatchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("HEC_BATCH_TIMEOUT: must be positive"))
+	}
+	if h.TokenRefresh < 0 {
+		errs = append(errs, fmt.Errorf("HEC_TOKEN_REFRESH: must not be negative"))
+	}
+	if h.StickyTTL < 0 {
+		errs = append(errs, fmt.Errorf("HEC_STICKY_TTL: must not be negative"))
+	}
+	if h.HealthInterval < 0 {
+		errs = append(errs, fmt.Errorf("HEC_HEALTH_CHECK_INTERVAL: must not be negative"))
+	}
+	if h.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("HEC_FAILURE_THRESHOLD: must not be negative"))
+	}
+	if h.CircuitCooldown < 0 {
+		errs = append(errs, fmt.Errorf("HEC_CIRCUIT_COOLDOWN: must not be negative"))
+	}
+
+	return errs
+}
+
+// NewPipeline creates the processing stages that run before delivery.
+// Secret references in the redaction HMAC key are resolved from secrets,
+// or used as-is when secrets is nil.
+func (c *Config) NewPipeline(ctx context.Context, secrets *secret.Registry) (processor.Chain, error) {
+	var chain processor.Chain
+
+	if len(c.Processing.Parsers) > 0 {
+		routes := make([]processor.ParseRoute, 0, len(c.Processing.Parsers))
+		for _, p := range c.Processing.Parsers {
+			routes = append(routes, processor.ParseRoute{
+				Name:       p.Name,
+				Match:      p.Match,
+				Format:     p.Format,
+				SourceType: p.SourceType,
+				Fields:     p.Fields,
+			})
+		}
+		parser, err := processor.NewParser(routes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parser)
+	}
+
+	if len(c.Processing.Filters) > 0 {
+		routes := make([]processor.FilterRoute, 0, len(c.Processing.Filters))
+		for _, f := range c.Processing.Filters {
+			routes = append(routes, processor.FilterRoute{
+				Name:          f.Name,
+				Match:         f.Match,
+				Include:       f.Include,
+				Exclude:       f.Exclude,
+				IncludeFields: f.IncludeFields,
+				ExcludeFields: f.ExcludeFields,
+				SampleRate:    f.SampleRate,
+				SampleKey:     f.SampleKey,
+			})
+		}
+		filter, err := processor.NewFilter(routes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, filter)
+	}
+
+	if r := c.Processing.Redact; r.enabled() {
+		hmacKey := r.HMACKey
+		if secrets != nil && hmacKey != "" {
+			var err error
+			if hmacKey, err = secrets.Resolve(ctx, hmacKey); err != nil {
+				return nil, fmt.Errorf("redact hmac_key: %w", err)
+			}
+		}
+		rules := make([]processor.RedactRule, 0, len(r.Rules))
+		for _, rule := range r.Rules {
+			rules = append(rules, processor.RedactRule{
+				Name:        rule.Name,
+				Pattern:     rule.Pattern,
+				Replacement: rule.Replacement,
+			})
+		}
+		redactor, err := processor.NewRedactor(processor.RedactConfig{
+			Builtin:    r.Builtin,
+			Rules:      rules,
+			DeleteKeys: r.DeleteKeys,
+			HashKeys:   r.HashKeys,
+			HMACKey:    hmacKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, redactor)
+	}
+
+	return chain, nil
+}
+
+// filter compiles the destination filter, returning nil when it is empty
+func (d *DestinationConfig) filter() (func(*models.Event) bool, error) {
+	if len(d.Filter) == 0 {
+		return nil, nil
+	}
+	patterns := make(map[string]*regexp.Regexp, len(d.Filter))
+	for field, pattern := range d.Filter {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %w", field, err)
+		}
+		patterns[field] = re
+	}
+	return func(event *models.Event) bool {
+		for field, re := range patterns {
+			if !re.MatchString(event.Field(field)) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// inheritDestinations fills unset destination fields from the top-level
+// HEC settings and the storage defaults
+func (c *Config) inheritDestinations() {
+	base := c.HEC
+	base.Endpoints = nil
+	base.EndpointConfigs = nil
+	base.Token = ""
+	storageBase := defaultStorage()
+	for i := range c.Destinations {
+		var keys map[string]interface{}
+		if i < len(c.destinationKeys) {
+			keys = c.destinationKeys[i]
+		}
+		hecKeys, _ := keys["hec"].(map[string]interface{})
+		storageKeys, _ := keys["failure_storage"].(map[string]interface{})
+		inherit(reflect.ValueOf(&c.Destinations[i].HEC).Elem(), reflect.ValueOf(base), hecKeys)
+		inherit(reflect.ValueOf(&c.Destinations[i].FailureStorage).Elem(), reflect.ValueOf(storageBase), storageKeys)
+	}
+}
+
+// inherit copies src fields into zero-valued dst fields, recursing into
+// structs. Booleans are copied unless keys, the YAML keys set for dst,
+// contains them, as false cannot be told apart from unset.
+func inherit(dst, src reflect.Value, keys map[string]interface{}) {
+	t := dst.Type()
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		switch {
+		case field.Kind() == reflect.Struct:
+			nested, _ := keys[key].(map[string]interface{})
+			inherit(field, src.Field(i), nested)
+		case field.Kind() == reflect.Bool:
+			if _, set := keys[key]; !set {
+				field.Set(src.Field(i))
+			}
+		case field.IsZero():
+			field.Set(src.Field(i))
+		}
+	}
+}
+
+// validate checks the storage settings. label names a setting by its YAML
+// key, as the env var or the config file path the value came from.
+func (s *StorageConfig) validate(label func(key string) string) []error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	var errs []error
+	if err := validateURL(s.URL, "http", "https"); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", label("url"), err))
+	}
+	if s.Endpoint != "" {
+		if err := validateURL(s.Endpoint, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", label("endpoint"), err))
+		}
+	}
+	if _, err := storage.NewEncoder(s.StorageConfig()); err != nil {
+		errs = append(errs, fmt.Errorf("%s/%s: %w", label("format"), label("compression"), err))
+	}
+	if _, err := storage.NewKeyTemplate(s.StorageConfig()); err != nil {
+		errs = append(errs, fmt.Errorf("%s/%s: %w", label("key_template"), label("key_time_source"), err))
+	}
+	if err := s3storage.ValidatePutOptions(s.StorageConfig()); err != nil {
+		errs = append(errs, fmt.Errorf("%s/%s/%s: %w", label("sse"), label("kms_key_id"), label("storage_class"), err))
+	}
+	if s.BufferMaxEvents < 0 || s.BufferMaxBytes < 0 || s.BufferMaxAge < 0 || s.BufferConcurrency < 0 || s.BufferMaxRetainBytes < 0 {
+		errs = append(errs, fmt.Errorf("%s: limits must not be negative", label("buffer_*")))
+	}
+	return errs
+}
+
+// envLabel names settings by their env var, e.g. S3_FORMAT
+func envLabel(prefix string) func(key string) string {
+	return func(key string) string {
+		return prefix + strings.ToUpper(key)
+	}
+}
+
+// fileLabel names settings by their path in the config file
+func fileLabel(prefix string) func(key string) string {
+	return func(key string) string {
+		return prefix + key
+	}
+}
+
+func validateURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL %q: missing host", raw)
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid URL %q: scheme must be one of %v", raw, schemes)
+}
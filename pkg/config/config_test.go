@@ -0,0 +1,488 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+func TestLoad_Env(t *testing.T) {
+	t.Setenv("HEC_ENDPOINTS", "https://splunk1:8088, https://splunk2:8088")
+	t.Setenv("HEC_BATCH_TIMEOUT", "5s")
+	t.Setenv("HEC_TLS_SKIP_VERIFY", "false")
+	t.Setenv("S3_COLD_STORAGE_URL", "https://bucket.s3.us-east-1.amazonaws.com/cold/")
+	t.Setenv("S3_COLD_STORAGE_TAGS", "team=security,env=prod")
+	t.Setenv("HEC_MULTILINE_CONTINUATION", `^\s+at `)
+	t.Setenv("TRACING_ENDPOINT", "http://collector:4318")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	t.Setenv("HEC_LOOP_DENY_LOG_GROUPS", "/aws/lambda/forwarder-*")
+
+	cfg := Default()
+	if err := Load(&cfg, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cfg.HEC.Endpoints) != 2 || cfg.HEC.Endpoints[1] != "https://splunk2:8088" {
+		t.Errorf("Unexpected endpoints %v", cfg.HEC.Endpoints)
+	}
+	if cfg.HEC.BatchTimeout != 5*time.Second {
+		t.Errorf("Expected batch timeout 5s, got %v", cfg.HEC.BatchTimeout)
+	}
+	if cfg.HEC.TLSSkipVerify {
+		t.Error("Expected TLS verification to be enabled")
+	}
+	if !cfg.ColdStorage.Enabled() || cfg.FailureStorage.Enabled() {
+		t.Error("Expected only cold storage to be enabled")
+	}
+	if cfg.ColdStorage.Tags["team"] != "security" {
+		t.Errorf("Unexpected tags %v", cfg.ColdStorage.Tags)
+	}
+	if cfg.HEC.Index != "main" {
+		t.Errorf("Expected default index 'main', got '%s'", cfg.HEC.Index)
+	}
+	if multiline, err := cfg.HEC.NewMultiline(); err != nil || multiline == nil {
+		t.Errorf("Expected multiline rules, got %v (%v)", multiline, err)
+	}
+	if cfg.HEC.Multiline.MaxLines != 500 {
+		t.Errorf("Expected default multiline max lines 500, got %d", cfg.HEC.Multiline.MaxLines)
+	}
+	if guard, err := cfg.HEC.NewLoopGuard("/aws/lambda/forwarder"); err != nil || guard == nil || guard.Allow("/aws/lambda/forwarder-2", 1) {
+		t.Errorf("Expected loop guard to deny forwarder log groups, got %v (%v)", guard, err)
+	}
+	if tc := cfg.Tracing.Tracing(); tc.Endpoint != "http://collector:4318" || tc.SampleRatio != 0.25 || tc.ServiceName != "whatthehec" {
+		t.Errorf("Unexpected tracing settings %+v", tc)
+	}
+}
+
+func TestLoad_FilePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(path, []byte(`
+hec:
+  endpoints:
+    - https://from-file:8088
+  index: file-index
+  source: file-source
+  batch_timeout: 10s
+cold_storage:
+  url: https://bucket.s3.us-east-1.amazonaws.com/cold/
+  format: parquet
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HEC_INDEX", "env-index")
+	t.Setenv("HEC_SOURCE", "env-source")
+
+	cfg := Default()
+	err = Load(&cfg, []string{"--whatthehec-config", path, "--hec-source", "flag-source"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.HEC.Endpoints[0] != "https://from-file:8088" {
+		t.Errorf("Expected endpoint from file, got %v", cfg.HEC.Endpoints)
+	}
+	if cfg.HEC.BatchTimeout != 10*time.Second {
+		t.Errorf("Expected batch timeout from file, got %v", cfg.HEC.BatchTimeout)
+	}
+	if cfg.HEC.Index != "env-index" {
+		t.Errorf("Expected env to override file, got '%s'", cfg.HEC.Index)
+	}
+	if cfg.HEC.Source != "flag-source" {
+		t.Errorf("Expected flag to override env, got '%s'", cfg.HEC.Source)
+	}
+	if cfg.ColdStorage.Format != "parquet" || cfg.ColdStorage.Compression != "gzip" {
+		t.Errorf("Expected file values merged with defaults, got %s/%s", cfg.ColdStorage.Format, cfg.ColdStorage.Compression)
+	}
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"hec": {"endpoints": ["https://splunk:8088"], "balance": "sticky"}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WHATTHEHEC_CONFIG", path)
+
+	cfg := Default()
+	if err := Load(&cfg, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.HEC.Balance != "sticky" {
+		t.Errorf("Expected balance from JSON file, got '%s'", cfg.HEC.Balance)
+	}
+}
+
+func TestLoad_ReportsAllErrors(t *testing.T) {
+	t.Setenv("HEC_ENDPOINTS", "splunk:8088")
+	t.Setenv("HEC_BATCH_TIMEOUT", "2 seconds")
+	t.Setenv("HEC_BALANCE", "fastest")
+	t.Setenv("HEC_EXTRACT_LOG_EVENTS", "yes please")
+	t.Setenv("HEC_PLATFORM_LOGS", "summarize")
+	t.Setenv("HEC_MULTILINE_START", "[")
+	t.Setenv("S3_URL", "https://bucket.s3.us-east-1.amazonaws.com/failed/")
+	t.Setenv("S3_FORMAT", "xml")
+	t.Setenv("TRACING_SAMPLE_RATIO", "2")
+	t.Setenv("LOG_LEVEL", "verbose")
+	t.Setenv("HEC_LOOP_MAX_EVENTS", "-1")
+	t.Setenv("HEC_FAILURE_THRESHOLD", "-1")
+	t.Setenv("SHUTDOWN_TIMEOUT", "0s")
+
+	cfg := Default()
+	err := Load(&cfg, nil)
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+
+	for _, want := range []string{"HEC_ENDPOINTS", "HEC_BATCH_TIMEOUT", "HEC_BALANCE", "HEC_EXTRACT_LOG_EVENTS", "HEC_PLATFORM_LOGS", "HEC_MULTILINE", "S3_FORMAT", "TRACING_SAMPLE_RATIO", "LOG_LEVEL", "HEC_LOOP", "HEC_FAILURE_THRESHOLD", "SHUTDOWN_TIMEOUT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_UnknownFileField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("hec:\n  endpoint: https://splunk:8088\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	if err := Load(&cfg, []string{"--whatthehec-config=" + path}); err == nil {
+		t.Error("Expected error for unknown field, got nil")
+	}
+}
+
+func TestValidate_RequiresEndpoints(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "HEC_ENDPOINTS is required") {
+		t.Errorf("Expected missing endpoints error, got %v", err)
+	}
+}
+
+func TestLoad_EndpointConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+hec:
+  token: env://TEST_SHARED_TOKEN
+  endpoint_configs:
+    - url: https://splunk-cloud:443
+      token: env://TEST_CLOUD_TOKEN
+      weight: 3
+    - url: https://onprem:8088
+      tls_skip_verify: false
+      proxy: http://proxy:3128
+      timeout: 10s
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SHARED_TOKEN", "shared-token")
+	t.Setenv("TEST_CLOUD_TOKEN", "cloud-token")
+
+	cfg := Default()
+	if err := Load(&cfg, []string{"--whatthehec-config", path}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	refs, err := cfg.HEC.resolveTokens(context.Background(), secret.NewRegistry())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(refs) != 2 || refs[""] != "env://TEST_SHARED_TOKEN" || refs["https://splunk-cloud:443"] != "env://TEST_CLOUD_TOKEN" {
+		t.Errorf("Unexpected token references %v", refs)
+	}
+
+	hecCfg := cfg.HECClientConfig()
+	if hecCfg.Token != "shared-token" || len(hecCfg.EndpointConfigs) != 2 {
+		t.Fatalf("Unexpected HEC config %+v", hecCfg)
+	}
+	cloud, onprem := hecCfg.EndpointConfigs[0], hecCfg.EndpointConfigs[1]
+	if cloud.Token != "cloud-token" || cloud.Weight != 3 || cloud.TLSSkipVerify != nil {
+		t.Errorf("Unexpected cloud endpoint %+v", cloud)
+	}
+	if onprem.Token != "" || onprem.TLSSkipVerify == nil || *onprem.TLSSkipVerify || onprem.Timeout != 10*time.Second {
+		t.Errorf("Unexpected on-prem endpoint %+v", onprem)
+	}
+}
+
+func TestNewSender_ShutdownStopsRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer server.Close()
+
+	// The first read resolves the token, later ones hang until cancelled
+	refreshing := make(chan struct{}, 1)
+	stopped := make(chan struct{}, 1)
+	calls := 0
+	secrets := secret.NewRegistry()
+	secrets.Register("test", secret.SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		calls++
+		if calls == 1 {
+			return "token", nil
+		}
+		select {
+		case refreshing <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		stopped <- struct{}{}
+		return "", ctx.Err()
+	}))
+
+	cfg := Default()
+	cfg.HEC.Endpoints = []string{server.URL}
+	cfg.HEC.Token = "test://hec"
+	cfg.HEC.TokenRefresh = 10 * time.Millisecond
+	sender, err := cfg.NewSender(context.Background(), secrets, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	<-refreshing
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sender.Shutdown(ctx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Expected Shutdown to stop the token refresh")
+	}
+}
+
+func TestValidate_EndpointConfigs(t *testing.T) {
+	cfg := Default()
+	cfg.HEC.EndpointConfigs = []EndpointConfig{{URL: "onprem:8088", Weight: -1}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if strings.Contains(err.Error(), "HEC_ENDPOINTS is required") {
+		t.Error("Expected endpoint configs to satisfy the endpoints requirement")
+	}
+	for _, want := range []string{"endpoint_configs[0].url", "endpoint_configs[0].weight"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_StorageOptions(t *testing.T) {
+	cfg := Default()
+	cfg.HEC.Endpoints = []string{"https://splunk:8088"}
+	cfg.FailureStorage.URL = "https://bucket.s3.us-east-1.amazonaws.com/failed/"
+	cfg.FailureStorage.ServerSideEncryption = "rot13"
+	cfg.ColdStorage.URL = "https://bucket.s3.us-east-1.amazonaws.com/cold/"
+	cfg.ColdStorage.StorageClass = "COLD"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	for _, want := range []string{"S3_SSE", "unknown server side encryption", "S3_COLD_STORAGE_STORAGE_CLASS", "unknown storage class"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got: %v", want, err)
+		}
+	}
+
+	cfg.FailureStorage.ServerSideEncryption = "aws:kms"
+	cfg.ColdStorage.StorageClass = "GLACIER_IR"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestLoad_TLS(t *testing.T) {
+	t.Setenv("HEC_ENDPOINTS", "https://splunk:8088")
+	t.Setenv("HEC_TLS_MIN_VERSION", "1.3")
+	t.Setenv("HEC_TLS_SERVER_NAME", "splunk.internal")
+
+	cfg := Default()
+	if err := Load(&cfg, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.HEC.TLSSkipVerify {
+		t.Error("Expected TLS verification to be enabled by default")
+	}
+	hecCfg := cfg.HECClientConfig()
+	if hecCfg.TLS.MinVersion != "1.3" || hecCfg.TLS.ServerName != "splunk.internal" {
+		t.Errorf("Unexpected TLS settings %+v", hecCfg.TLS)
+	}
+
+	t.Setenv("HEC_TLS_CA_FILE", "/nonexistent/ca.pem")
+	t.Setenv("HEC_TLS_CERT_FILE", "/nonexistent/client.pem")
+	cfg = Default()
+	if err := Load(&cfg, nil); err == nil || !strings.Contains(err.Error(), "HEC_TLS_") {
+		t.Errorf("Expected TLS error, got %v", err)
+	}
+}
+
+func TestLoad_Destinations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+fanout_mode: best_effort
+hec:
+  index: shared-index
+  balance: first_available
+  batch_timeout: 7s
+  tls_skip_verify: true
+destinations:
+  - name: security
+    hec:
+      endpoints: [https://security:8088]
+      token: security-token
+    filter:
+      loggroup: ^/aws/cloudtrail
+  - name: ops
+    hec:
+      endpoints: [https://ops:8088]
+      token: ops-token
+      balance: roundrobin
+      tls_skip_verify: false
+    failure_storage:
+      url: https://bucket.s3.us-east-1.amazonaws.com/ops-failed/
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	if err := Load(&cfg, []string{"--whatthehec-config", path}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.FanOutMode != "best_effort" || len(cfg.Destinations) != 2 {
+		t.Fatalf("Unexpected fan-out config %s %d", cfg.FanOutMode, len(cfg.Destinations))
+	}
+
+	security, ops := cfg.Destinations[0], cfg.Destinations[1]
+	if security.HEC.Balance != "first_available" || security.HEC.BatchTimeout != 7*time.Second || security.HEC.BatchSize != 1 {
+		t.Errorf("Expected security to inherit HEC settings, got %+v", security.HEC)
+	}
+	if ops.HEC.Balance != "roundrobin" || ops.HEC.Token != "ops-token" {
+		t.Errorf("Expected ops overrides to be kept, got %+v", ops.HEC)
+	}
+	if !security.HEC.TLSSkipVerify || ops.HEC.TLSSkipVerify {
+		t.Errorf("Expected unset booleans to be inherited and set ones kept, got security=%v ops=%v",
+			security.HEC.TLSSkipVerify, ops.HEC.TLSSkipVerify)
+	}
+	if ops.FailureStorage.Format != "raw" || ops.FailureStorage.Compression != "gzip" {
+		t.Errorf("Expected storage defaults for ops, got %s/%s", ops.FailureStorage.Format, ops.FailureStorage.Compression)
+	}
+
+	filter, err := security.filter()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	trail := &models.Event{Metadata: map[string]string{"loggroup": "/aws/cloudtrail/org"}}
+	app := &models.Event{Metadata: map[string]string{"loggroup": "/aws/lambda/app"}}
+	if !filter(trail) || filter(app) {
+		t.Error("Expected filter to select only CloudTrail events")
+	}
+}
+
+func TestValidate_Destinations(t *testing.T) {
+	cfg := Default()
+	cfg.FanOutMode = "most"
+	cfg.Destinations = []DestinationConfig{
+		{Name: "a", HEC: Default().HEC, Filter: map[string]string{"index": "("}},
+		{Name: "a", HEC: Default().HEC},
+	}
+	cfg.Destinations[1].FailureStorage = defaultStorage()
+	cfg.Destinations[1].FailureStorage.URL = "ftp://bucket/failed/"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	for _, want := range []string{"HEC_FANOUT_MODE", "destinations[0] (a): HEC_ENDPOINTS is required", "filter index", "destinations[1]: name must be set and unique", "destinations[1] (a): failure_storage.url"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_Processing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+hec:
+  endpoints: [https://splunk:8088]
+processing:
+  parsers:
+    - match:
+        loggroup: ^/vpc/
+      format: vpcflow
+    - format: auto
+  filters:
+    - name: lambda
+      match:
+        loggroup: ^/aws/lambda/
+      exclude: ["GET /health"]
+      exclude_fields:
+        $.level: DEBUG
+      sample_rate: 0.5
+      sample_key: $.requestId
+  redact:
+    builtin: [email]
+    rules:
+      - name: ssn
+        pattern: '\d{3}-\d{2}-\d{4}'
+    hash_keys: [userId]
+    hmac_key: env://TEST_REDACT_KEY
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	if err := Load(&cfg, []string{"--whatthehec-config", path}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Setenv("TEST_REDACT_KEY", "redact-key")
+	pipeline, err := cfg.NewPipeline(context.Background(), secret.NewRegistry())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(pipeline) != 3 {
+		t.Errorf("Expected parse, filter and redact stages, got %d", len(pipeline))
+	}
+	if key := cfg.Processing.Filters[0].SampleKey; key != "$.requestId" {
+		t.Errorf("Expected sample key '$.requestId', got '%s'", key)
+	}
+
+	events, _ := pipeline.Process(context.Background(), []*models.Event{{Event: "ssn 123-45-6789"}})
+	if len(events) != 1 || events[0].Event != "ssn [REDACTED]" {
+		t.Errorf("Expected redacted event, got %v", events)
+	}
+
+	tooHigh := 2.0
+	cfg.Processing.Filters[0].SampleRate = &tooHigh
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "processing") {
+		t.Errorf("Expected processing error, got %v", err)
+	}
+
+	cfg.Processing.Filters[0].SampleRate = nil
+	cfg.Processing.Parsers[1].Format = "syslog"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "unknown log format") {
+		t.Errorf("Expected log format error, got %v", err)
+	}
+
+	cfg.Processing.Parsers[1].Format = "auto"
+	cfg.Processing.Redact.HMACKey = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "HMAC key") {
+		t.Errorf("Expected HMAC key error, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// field is a single configuration value addressable by env var and flag
+type field struct {
+	env   string
+	help  string
+	value reflect.Value
+}
+
+// Load fills cfg from, in increasing order of precedence, the config file
+// named by WHATTHEHEC_CONFIG or --whatthehec-config, environment variables
+// and command line flags, then validates the result. All problems are
+// reported together.
+func Load(cfg *Config, args []string) error {
+	fields := collectFields(reflect.ValueOf(cfg).Elem(), "")
+	var errs []error
+
+	if path := configFile(args); path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, loadEnv(fields)...)
+
+	flagErrs, err := loadFlags(fields, args)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, flagErrs...)
+
+	cfg.inheritDestinations()
+	errs = append(errs, cfg.Validate())
+	return errors.Join(errs...)
+}
+
+// collectFields walks the struct and returns every field with an env tag.
+// Nested structs use their env tag as a prefix for their fields.
+func collectFields(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		env, ok := sf.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(v.Field(i), prefix+env)...)
+			continue
+		}
+		fields = append(fields, field{
+			env:   prefix + env,
+			help:  sf.Tag.Get("help"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+// flagName turns HEC_BATCH_TIMEOUT into hec-batch-timeout
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+// configFile finds the config file path from flags or the environment
+func configFile(args []string) string {
+	name := flagName("WHATTHEHEC_CONFIG")
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if path, ok := strings.CutPrefix(arg, name+"="); ok {
+			return path
+		}
+	}
+	return os.Getenv("WHATTHEHEC_CONFIG")
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder handles both
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Record which destination settings the file sets
+	var keys struct {
+		Destinations []map[string]interface{} `yaml:"destinations"`
+	}
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	cfg.destinationKeys = keys.Destinations
+	return nil
+}
+
+func loadEnv(fields []field) []error {
+	var errs []error
+	for _, f := range fields {
+		value := os.Getenv(f.env)
+		if value == "" {
+			continue
+		}
+		if err := setValue(f.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	}
+	return errs
+}
+
+// loadFlags parses the command line. Value errors are collected rather
+// than stopping at the first one; the returned error is only set for
+// unknown flags or -help.
+func loadFlags(fields []field, args []string) ([]error, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	var errs []error
+	for _, f := range fields {
+		f := f
+		name := flagName(f.env)
+		set := func(s string) error {
+			if err := setValue(f.value, s); err != nil {
+				errs = append(errs, fmt.Errorf("--%s: %w", name, err))
+			}
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, f.help, set)
+		} else {
+			fs.Func(name, f.help, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue parses s into v according to its type
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, pair := range strings.Split(s, ",") {
+			k, val, ok := strings.Cut(pair, "=")
+			if k = strings.TrimSpace(k); !ok || k == "" {
+				return fmt.Errorf("invalid key=value pair %q", pair)
+			}
+			m[k] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
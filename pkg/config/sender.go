@@ -0,0 +1,140 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+// StorageFactory creates a storage backend for an enabled StorageConfig
+type StorageFactory func(StorageConfig) (storage.StorageBackend, error)
+
+// NewSender creates the HEC client, or a fan-out over one client per
+// destination when destinations are configured. Tokens are resolved through
+// secrets and kept refreshed until ctx is cancelled or the sender is shut
+// down. newStorage may be nil
+// where storage is not supported; backends that fail to open are logged
+// and skipped.
+func (c *Config) NewSender(ctx context.Context, secrets *secret.Registry, newStorage StorageFactory) (hec.Sender, error) {
+	openStorage := func(name string, s StorageConfig) storage.StorageBackend {
+		if newStorage == nil || !s.Enabled() {
+			return nil
+		}
+		backend, err := newStorage(s)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to set up storage", "storage", name, "error", err)
+			return nil
+		}
+		return backend
+	}
+
+	coldStorage := openStorage("cold storage", c.ColdStorage)
+	if len(c.Destinations) == 0 {
+		return c.HEC.newClient(ctx, secrets, openStorage("failure storage", c.FailureStorage), coldStorage)
+	}
+
+	destinations := make([]hec.Destination, 0, len(c.Destinations))
+	for _, d := range c.Destinations {
+		failureStorage := openStorage(d.Name+" failure storage", d.FailureStorage)
+		client, err := d.HEC.newClient(ctx, secrets, failureStorage, nil)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		filter, err := d.filter()
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		destinations = append(destinations, hec.Destination{
+			Name:   d.Name,
+			Client: client,
+			Filter: filter,
+		})
+	}
+	return hec.NewFanOut(c.FanOutMode, destinations, coldStorage)
+}
+
+// newClient resolves the tokens and creates a hec.Client
+func (h *HECConfig) newClient(ctx context.Context, secrets *secret.Registry, failureStorage, coldStorage storage.StorageBackend) (*hec.Client, error) {
+	refs, err := h.resolveTokens(ctx, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEC token: %w", err)
+	}
+	client, err := hec.NewClient(h.client(), failureStorage, coldStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick up rotated tokens without a redeploy
+	h.refreshTokens(ctx, secrets, refs, client)
+	return client, nil
+}
+
+// resolveTokens replaces the HEC token and per-endpoint tokens with their
+// values from secrets. It returns the references that came from a secret
+// store, keyed by endpoint URL with "" for the client-wide token.
+func (h *HECConfig) resolveTokens(ctx context.Context, secrets *secret.Registry) (map[string]string, error) {
+	refs := make(map[string]string)
+	resolve := func(endpoint string, token *string) error {
+		ref := *token
+		value, err := secrets.Resolve(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if secrets.Handles(ref) {
+			refs[endpoint] = ref
+		}
+		*token = value
+		return nil
+	}
+
+	var errs []error
+	if err := resolve("", &h.Token); err != nil {
+		errs = append(errs, fmt.Errorf("HEC_TOKEN: %w", err))
+	}
+	for i := range h.EndpointConfigs {
+		e := &h.EndpointConfigs[i]
+		if err := resolve(e.URL, &e.Token); err != nil {
+			errs = append(errs, fmt.Errorf("hec.endpoint_configs[%d].token: %w", i, err))
+		}
+	}
+	return refs, errors.Join(errs...)
+}
+
+// refreshTokens re-reads the references returned by resolveTokens every
+// HEC_TOKEN_REFRESH and updates client, until ctx is cancelled or client
+// is shut down
+func (h *HECConfig) refreshTokens(ctx context.Context, secrets *secret.Registry, refs map[string]string, client *hec.Client) {
+	if h.TokenRefresh <= 0 {
+		return
+	}
+	refresh := func(ref, current string, update func(string)) {
+		client.Go(func(clientCtx context.Context) {
+			// Stop with whichever ends first, ctx or the client
+			refreshCtx, cancel := context.WithCancel(clientCtx)
+			defer cancel()
+			defer context.AfterFunc(ctx, cancel)()
+			secret.Refresh(refreshCtx, secrets, ref, current, h.TokenRefresh, update)
+		})
+	}
+	for endpoint, ref := range refs {
+		if endpoint == "" {
+			refresh(ref, h.Token, client.SetToken)
+			continue
+		}
+		for _, e := range h.EndpointConfigs {
+			if e.URL != endpoint {
+				continue
+			}
+			refresh(ref, e.Token, func(token string) {
+				if err := client.SetEndpointToken(endpoint, token); err != nil {
+					slog.ErrorContext(ctx, "Failed to update token", "endpoint", endpoint, "error", err)
+				}
+			})
+		}
+	}
+}
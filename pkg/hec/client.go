@@ -1,13 +1,19 @@
 package hec
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,6 +26,7 @@ import (
 type Config struct {
 	Endpoints        []string
 	TLSSkipVerify    bool
+	TLS              TLSConfig
 	Proxy            string
 	Token            string
 	ChannelID        string
@@ -29,19 +36,54 @@ type Config struct {
 	Host             string
 	BatchSize        int
 	BatchTimeout     time.Duration
-	BalanceStrategy  string // first_available, sticky, random, roundrobin
-	StickyTTL        time.Duration
+	BalanceStrategy  string        // first_available, sticky, random, roundrobin
+	StickyTTL        time.Duration // time on one sticky endpoint before the next, 0 for no limit
 	ExtractLogEvents bool
+	// EndpointConfigs are endpoints with their own settings, used in
+	// addition to Endpoints
+	EndpointConfigs []EndpointConfig
+	// HealthCheckInterval is how often each endpoint's health endpoint is
+	// probed, DefaultHealthCheckInterval if zero
+	HealthCheckInterval time.Duration
+	// FailureThreshold is the number of consecutive failed sends that opens
+	// an endpoint's circuit; 0 disables passive health tracking
+	FailureThreshold int
+	// CircuitCooldown is how long an open circuit stays open before the
+	// endpoint is tried again, DefaultCircuitCooldown if zero
+	CircuitCooldown time.Duration
 }
 
-// Client manages HEC connections and event delivery
+// EndpointConfig holds settings for a single HEC endpoint. Zero values
+// inherit the client-wide settings from Config.
+type EndpointConfig struct {
+	URL           string
+	Token         string
+	TLSSkipVerify *bool
+	TLS           TLSConfig
+	Proxy         string
+	Timeout       time.Duration
+	Weight        int // relative share of traffic for random and roundrobin
+}
+
+// Client manages HEC connections and event delivery. It is safe for
+// concurrent use.
 type Client struct {
 	config          Config
 	connections     []*connection
 	failureStorage  storage.StorageBackend
 	coldStorage     storage.StorageBackend
 	balanceStrategy uint8
-	count           int
+	token           *atomic.Pointer[string]
+
+	mu          sync.Mutex
+	count       int       // next sticky or roundrobin slot, guarded by mu
+	stickySince time.Time // when the sticky slot was chosen, guarded by mu
+
+	// ctx is cancelled by Shutdown to stop the health checks
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+	life lifecycle
 }
 
 const (
@@ -52,9 +94,14 @@ const (
 )
 
 type connection struct {
-	endpoint  string
-	client    *splunk.Client
-	isHealthy bool
+	endpoint string
+	client   *splunk.Client
+	weight   int
+	token    *atomic.Pointer[string]
+
+	mu        sync.RWMutex
+	isHealthy bool    // last probe result, guarded by mu
+	circuit   circuit // passive health, guarded by mu
 }
 
 // NewClient creates a new HEC client
@@ -64,7 +111,11 @@ func NewClient(cfg Config, failureStorage, coldStorage storage.StorageBackend) (
 		connections:    make([]*connection, 0),
 		failureStorage: failureStorage,
 		coldStorage:    coldStorage,
+		token:          new(atomic.Pointer[string]),
+		stickySince:    time.Now(),
 	}
+	client.ctx, client.stop = context.WithCancel(context.Background())
+	client.token.Store(&cfg.Token)
 
 	// Parse balance strategy
 	switch cfg.BalanceStrategy {
@@ -77,19 +128,25 @@ func NewClient(cfg Config, failureStorage, coldStorage storage.StorageBackend) (
 	case "roundrobin":
 		client.balanceStrategy = RoundRobin
 	default:
-		log.Printf("Unknown load balance strategy: %v. Using first_available", cfg.BalanceStrategy)
+		slog.Warn("Unknown load balance strategy, using first_available", "strategy", cfg.BalanceStrategy)
 		client.balanceStrategy = FirstAvailable
 	}
 
 	// Create connections for each endpoint
-	for _, endpoint := range cfg.Endpoints {
-		conn, err := newConnection(endpoint, cfg)
+	for _, endpoint := range cfg.endpoints() {
+		token := client.token
+		if endpoint.Token != "" {
+			token = new(atomic.Pointer[string])
+			token.Store(&endpoint.Token)
+		}
+		conn, err := newConnection(endpoint, cfg, token)
 		if err != nil {
-			log.Printf("Failed to create connection to %s: %v", endpoint, err)
+			slog.Error("Failed to create connection", "endpoint", endpoint.URL, "error", err)
 			continue
 		}
 		client.connections = append(client.connections, conn)
-		go conn.healthCheck()
+		client.wg.Add(1)
+		go client.healthCheck(conn)
 	}
 
 	if len(client.connections) == 0 {
@@ -99,28 +156,69 @@ func NewClient(cfg Config, failureStorage, coldStorage storage.StorageBackend) (
 	return client, nil
 }
 
-func newConnection(endpoint string, cfg Config) (*connection, error) {
+// endpoints returns Endpoints and EndpointConfigs with client-wide
+// defaults applied
+func (cfg Config) endpoints() []EndpointConfig {
+	endpoints := make([]EndpointConfig, 0, len(cfg.Endpoints)+len(cfg.EndpointConfigs))
+	for _, endpoint := range cfg.Endpoints {
+		endpoints = append(endpoints, EndpointConfig{URL: endpoint})
+	}
+	endpoints = append(endpoints, cfg.EndpointConfigs...)
+
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if endpoint.TLSSkipVerify == nil {
+			endpoint.TLSSkipVerify = &cfg.TLSSkipVerify
+		}
+		endpoint.TLS = endpoint.TLS.merge(cfg.TLS)
+		if endpoint.Proxy == "" {
+			endpoint.Proxy = cfg.Proxy
+		}
+		if endpoint.Timeout == 0 {
+			endpoint.Timeout = cfg.BatchTimeout
+		}
+		if endpoint.Weight <= 0 {
+			endpoint.Weight = 1
+		}
+	}
+	return endpoints
+}
+
+// collectorURL appends the collector path to an endpoint URL
+func collectorURL(endpoint string) string {
+	if !strings.HasSuffix(endpoint, "/services/collector") {
+		endpoint = fmt.Sprintf("%s/services/collector", endpoint)
+	}
+	return endpoint
+}
+
+func newConnection(ep EndpointConfig, cfg Config, token *atomic.Pointer[string]) (*connection, error) {
+	tlsConfig, err := ep.TLS.ClientConfig(*ep.TLSSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings: %w", err)
+	}
 	rt := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify},
+		TLSClientConfig: tlsConfig,
 	}
 	httpClient := &http.Client{
-		Timeout:   cfg.BatchTimeout,
+		Timeout:   ep.Timeout,
 		Transport: rt,
 	}
 
-	if cfg.Proxy != "" {
-		proxyURL, err := url.Parse(cfg.Proxy)
+	if ep.Proxy != "" {
+		proxyURL, err := url.Parse(ep.Proxy)
 		if err != nil {
 			return nil, fmt.Errorf("invalid proxy URL: %w", err)
 		}
-		httpClient.Transport = &http.Transport{
-			Proxy:           http.ProxyURL(proxyURL),
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify},
-		}
+		rt.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	if !strings.HasSuffix(endpoint, "/services/collector") {
-		endpoint = fmt.Sprintf("%s/services/collector", endpoint)
+	endpoint := collectorURL(ep.URL)
+
+	// Authorize every request with the current token so it can be rotated
+	httpClient.Transport = &tokenTransport{
+		base:  &metricsTransport{base: httpClient.Transport, endpoint: endpoint},
+		token: token,
 	}
 
 	channelID := cfg.ChannelID
@@ -135,44 +233,128 @@ func newConnection(endpoint string, cfg Config) (*connection, error) {
 	splunkClient := splunk.NewClient(
 		httpClient,
 		endpoint,
-		cfg.Token,
+		*token.Load(),
 		channelID,
 		cfg.Source,
 		cfg.SourceType,
 		cfg.Index,
 	)
 
+	cooldown := cfg.CircuitCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultCircuitCooldown
+	}
 	conn := &connection{
 		endpoint: endpoint,
 		client:   splunkClient,
+		weight:   ep.Weight,
+		token:    token,
+		circuit:  circuit{threshold: cfg.FailureThreshold, cooldown: cooldown},
 	}
-	conn.updateHealth()
+	conn.updateHealth(context.Background())
 
 	return conn, nil
 }
 
-func (c *connection) updateHealth() {
-	c.isHealthy = c.client.CheckHealth() == nil
+// logEvents posts events as splunk.Client.LogEvents does, but bound to
+// ctx so that the caller's deadline and cancellation apply
+func (c *connection) logEvents(ctx context.Context, events []*splunk.Event) error {
+	body := new(bytes.Buffer)
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		body.Write(b)
+		body.WriteString("\r\n\r\n")
+	}
+
+	return c.do(ctx, http.MethodPost, c.client.URL, body)
+}
+
+// checkHealth queries the endpoint's health as splunk.Client.CheckHealth
+// does, but bound to ctx
+func (c *connection) checkHealth(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, c.client.URL+"/health/1.0", http.NoBody)
 }
 
-func (c *connection) healthCheck() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// do sends a request to the endpoint and returns the HEC response as an
+// error unless it succeeded
+func (c *connection) do(ctx context.Context, method, url string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", c.client.ChannelID)
+
+	resp, err := c.client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
 
-	for range ticker.C {
-		c.updateHealth()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
+	hecResp := &splunk.EventCollectorResponse{}
+	if err := json.Unmarshal(respBody, hecResp); err == nil {
+		return hecResp
+	}
+	return fmt.Errorf("HEC returned %s: %s", resp.Status, respBody)
+}
+
+// tokenTransport sets the Authorization header from a token that may be
+// replaced while requests are in flight
+type tokenTransport struct {
+	base  http.RoundTripper
+	token *atomic.Pointer[string]
 }
 
-// SendEvents sends events to HEC with fallback to storage
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Splunk "+*t.token.Load())
+	return t.base.RoundTrip(req)
+}
+
+// SendEvents sends events to HEC with fallback to storage. It returns an
+// error if any event was neither delivered nor stored; see Send.
 func (c *Client) SendEvents(ctx context.Context, events []*models.Event) error {
+	return c.Send(ctx, events).Err()
+}
+
+// Send sends events to HEC with fallback to storage and reports the
+// outcome of each event
+func (c *Client) Send(ctx context.Context, events []*models.Event) *Result {
+	if !c.life.begin(len(events)) {
+		return newResult(len(events), Dropped, ErrClosed)
+	}
+	defer c.life.end(len(events))
+
 	// Send to cold storage if configured
+	var coldErr error
 	if c.coldStorage != nil {
-		if err := c.coldStorage.Store(ctx, events); err != nil {
-			log.Printf("Failed to send events to cold storage: %v", err)
+		coldErr = c.coldStorage.Store(ctx, events)
+		observeStorage("cold", len(events), coldErr)
+		if coldErr != nil {
+			slog.WarnContext(ctx, "Failed to send events to cold storage", "count", len(events), "error", coldErr)
 		}
 	}
 
+	result := c.send(ctx, events)
+	result.ColdStorage = coldErr
+	return result
+}
+
+// send delivers events to HEC, trying the other available connections
+// when an endpoint fails. Events HEC does not accept go to failure
+// storage.
+func (c *Client) send(ctx context.Context, events []*models.Event) *Result {
 	// Convert to splunk events
 	splunkEvents := make([]*splunk.Event, len(events))
 	for i, event := range events {
@@ -187,17 +369,107 @@ func (c *Client) SendEvents(ctx context.Context, events []*models.Event) error {
 	}
 
 	// Get a healthy connection
-	conn := c.getConnection()
+	tried := make(map[*connection]bool)
+	conn := c.claim(c.getConnection(), tried)
 	if conn == nil {
-		log.Printf("No healthy HEC connection available, sending to failure storage")
+		slog.WarnContext(ctx, "No healthy HEC connection available, sending to failure storage", "count", len(events))
 		if c.failureStorage != nil {
-			return c.failureStorage.Store(ctx, events)
+			if err := c.spill(ctx, events); err != nil {
+				return newResult(len(events), Dropped, err)
+			}
+			return newResult(len(events), Stored, nil)
+		}
+		return newResult(len(events), Dropped, fmt.Errorf("no healthy connections and no failure storage configured"))
+	}
+
+	// Send to HEC, failing over while the endpoint is at fault
+	sendCtx, span := startSendSpan(ctx, len(events))
+	var result *Result
+	var responses []Response
+	var sendErr error
+	attempts := 0
+	endpoint := conn.endpoint
+	for ; conn != nil; conn = c.claim(c.nextConnection(tried), tried) {
+		if sendErr != nil {
+			recordAttempt(span, endpoint, sendErr)
+			retriesTotal.Inc(endpoint)
+		}
+		attempts++
+		endpoint = conn.endpoint
+		sendErr = conn.logEvents(sendCtx, splunkEvents)
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the endpoint
+			conn.abandon()
+		} else {
+			conn.recordSend(sendErr)
+		}
+
+		result = sendResult(conn.endpoint, len(events), sendErr)
+		responses = append(responses, result.Responses...)
+		if delivered := result.Count(Delivered); delivered > 0 {
+			eventsTotal.Add(float64(delivered), conn.endpoint, "sent")
+		}
+		if failed := len(events) - result.Count(Delivered); failed > 0 {
+			eventsTotal.Add(float64(failed), conn.endpoint, "failed")
 		}
-		return fmt.Errorf("no healthy connections and no failure storage configured")
+		if sendErr == nil || !endpointFailure(sendErr) || ctx.Err() != nil {
+			break
+		}
+		slog.WarnContext(ctx, "HEC send failed, trying the next endpoint", "endpoint", conn.endpoint, "error", sendErr)
+	}
+	endSendSpan(span, endpoint, attempts, sendErr)
+	result.Responses = responses
+
+	// Keep what HEC did not accept, from the invalid event onwards
+	delivered := result.Count(Delivered)
+	if delivered == len(events) || c.failureStorage == nil {
+		return result
+	}
+	undelivered := events[delivered:]
+	slog.WarnContext(ctx, "Sending undelivered events to failure storage", "count", len(undelivered), "error", sendErr)
+	if err := c.spill(ctx, undelivered); err != nil {
+		result.set(delivered, len(events), Dropped, errors.Join(sendErr, err))
+	} else {
+		result.set(delivered, len(events), Stored, sendErr)
 	}
+	return result
+}
+
+// spillTimeout bounds a write to failure storage
+const spillTimeout = 10 * time.Second
 
-	// Send to HEC
-	return conn.client.LogEvents(splunkEvents)
+// spill writes events to failure storage. Sends often fail because ctx
+// ended, so the write keeps ctx's values but has its own deadline.
+func (c *Client) spill(ctx context.Context, events []*models.Event) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), spillTimeout)
+	defer cancel()
+	err := c.failureStorage.Store(ctx, events)
+	observeStorage("failure", len(events), err)
+	return err
+}
+
+// claim returns conn, or the next available connection not yet tried,
+// once it is acquired for a send. A connection whose half-open probe was
+// taken by another send is skipped.
+func (c *Client) claim(conn *connection, tried map[*connection]bool) *connection {
+	for ; conn != nil; conn = c.nextConnection(tried) {
+		tried[conn] = true
+		if conn.acquire() {
+			return conn
+		}
+	}
+	return nil
+}
+
+// nextConnection returns the first available connection not yet tried,
+// in configuration order
+func (c *Client) nextConnection(tried map[*connection]bool) *connection {
+	for _, conn := range c.connections {
+		if !tried[conn] && conn.available() {
+			return conn
+		}
+	}
+	return nil
 }
 
 func (c *Client) getConnection() *connection {
@@ -217,36 +489,129 @@ func (c *Client) getConnection() *connection {
 
 func (c *Client) getFirstAvailable() *connection {
 	for _, conn := range c.connections {
-		if conn.isHealthy {
+		if conn.available() {
 			return conn
 		}
 	}
 	return nil
 }
 
+// getSticky returns the same connection until StickyTTL has passed or it
+// becomes unavailable, then moves on to the next available one
 func (c *Client) getSticky() *connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
 	if c.count >= len(c.connections) {
 		c.count = 0
 	}
-	conn := c.connections[c.count]
-	if conn.isHealthy {
+	if c.config.StickyTTL > 0 && now.Sub(c.stickySince) >= c.config.StickyTTL {
+		c.count = (c.count + 1) % len(c.connections)
+		c.stickySince = now
+	}
+	if conn := c.connections[c.count]; conn.available() {
 		return conn
 	}
+
+	for i := range c.connections {
+		slot := (c.count + i) % len(c.connections)
+		if conn := c.connections[slot]; conn.available() {
+			c.count, c.stickySince = slot, now
+			return conn
+		}
+	}
+	return nil
+}
+
+// healthyWeight returns the healthy connections and their total weight
+func (c *Client) healthyWeight() ([]*connection, int) {
+	healthy := make([]*connection, 0, len(c.connections))
+	total := 0
+	for _, conn := range c.connections {
+		if conn.available() {
+			healthy = append(healthy, conn)
+			total += conn.weight
+		}
+	}
+	return healthy, total
+}
+
+// pickWeighted returns the connection that owns slot n of total
+func pickWeighted(conns []*connection, n int) *connection {
+	for _, conn := range conns {
+		if n < conn.weight {
+			return conn
+		}
+		n -= conn.weight
+	}
 	return nil
 }
 
 func (c *Client) getRandom() *connection {
-	// TODO: implement random selection
-	return c.getFirstAvailable()
+	healthy, total := c.healthyWeight()
+	if total == 0 {
+		return nil
+	}
+	return pickWeighted(healthy, rand.Intn(total))
 }
 
 func (c *Client) getRoundRobin() *connection {
-	// TODO: implement round-robin
-	return c.getFirstAvailable()
+	healthy, total := c.healthyWeight()
+	if total == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	n := c.count % total
+	c.count++
+	c.mu.Unlock()
+	return pickWeighted(healthy, n)
 }
 
-// Close closes all connections
-func (c *Client) Close() error {
-	// Connections are closed automatically
-	return nil
+// Go runs fn in the background with a context that Shutdown and Close
+// cancel, and they wait for fn to return. Use it for work tied to the
+// client, such as refreshing its tokens; call it before Shutdown.
+func (c *Client) Go(fn func(ctx context.Context)) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn(c.ctx)
+	}()
+}
+
+// SetToken replaces the HEC token used by all connections, for example after
+// the secret holding it was rotated
+func (c *Client) SetToken(token string) {
+	c.token.Store(&token)
+}
+
+// SetEndpointToken replaces the token of an endpoint configured with its
+// own token in EndpointConfigs
+func (c *Client) SetEndpointToken(endpoint, token string) error {
+	endpoint = collectorURL(endpoint)
+	for _, conn := range c.connections {
+		if conn.endpoint == endpoint && conn.token != c.token {
+			conn.token.Store(&token)
+			return nil
+		}
+	}
+	return fmt.Errorf("no endpoint %s with its own token", endpoint)
+}
+
+// Flush writes any events buffered by the failure or cold storage backends.
+// Call it before returning from a function invocation so that nothing is
+// left in memory when the runtime freezes the process. Failure storage
+// errors wrap ErrNotStored.
+func (c *Client) Flush(ctx context.Context) error {
+	var errs []error
+	if flusher, ok := c.coldStorage.(storage.Flusher); ok {
+		if err := flusher.Flush(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flusher, ok := c.failureStorage.(storage.Flusher); ok {
+		if err := flusher.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrNotStored, err))
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -1,10 +1,32 @@
 package hec
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/mosajjal/whatthehec/pkg/metrics"
 	"github.com/mosajjal/whatthehec/pkg/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestConfig(t *testing.T) {
@@ -104,3 +126,874 @@ func TestConnection(t *testing.T) {
 		t.Errorf("Expected endpoint to be 'https://localhost:8088', got '%s'", conn.endpoint)
 	}
 }
+
+// flushStorage is a storage backend that records Flush calls
+type flushStorage struct {
+	flushed bool
+	closed  bool
+}
+
+func (f *flushStorage) Store(ctx context.Context, events []*models.Event) error { return nil }
+func (f *flushStorage) Close() error {
+	f.closed = true
+	return nil
+}
+func (f *flushStorage) Flush(ctx context.Context) error {
+	f.flushed = true
+	return nil
+}
+
+func TestClient_Flush(t *testing.T) {
+	cold := &flushStorage{}
+	client := &Client{coldStorage: cold}
+
+	if err := client.Flush(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cold.flushed {
+		t.Error("Expected cold storage to be flushed")
+	}
+}
+
+func TestClient_SetToken(t *testing.T) {
+	var mu sync.Mutex
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Endpoints:       []string{server.URL},
+		Token:           "old-token",
+		BalanceStrategy: "first_available",
+		BatchTimeout:    time.Second,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	client.SetToken("new-token")
+	if err := client.SendEvents(context.Background(), []*models.Event{{Event: "test"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auth) < 2 || auth[0] != "Splunk old-token" || auth[len(auth)-1] != "Splunk new-token" {
+		t.Errorf("Expected health check with old token and event with new token, got %v", auth)
+	}
+}
+
+func TestConfig_Endpoints(t *testing.T) {
+	skip := false
+	cfg := Config{
+		Endpoints:     []string{"https://primary:8088"},
+		TLSSkipVerify: true,
+		Proxy:         "http://proxy:3128",
+		BatchTimeout:  2 * time.Second,
+		EndpointConfigs: []EndpointConfig{{
+			URL:           "https://onprem:8088",
+			Token:         "onprem-token",
+			TLSSkipVerify: &skip,
+			Timeout:       10 * time.Second,
+			Weight:        3,
+		}},
+	}
+
+	endpoints := cfg.endpoints()
+	if len(endpoints) != 2 {
+		t.Fatalf("Expected 2 endpoints, got %d", len(endpoints))
+	}
+	if !*endpoints[0].TLSSkipVerify || endpoints[0].Proxy != cfg.Proxy || endpoints[0].Timeout != 2*time.Second || endpoints[0].Weight != 1 {
+		t.Errorf("Expected client-wide defaults, got %+v", endpoints[0])
+	}
+	if *endpoints[1].TLSSkipVerify || endpoints[1].Proxy != cfg.Proxy || endpoints[1].Timeout != 10*time.Second || endpoints[1].Weight != 3 {
+		t.Errorf("Expected endpoint overrides, got %+v", endpoints[1])
+	}
+}
+
+func TestClient_WeightedRoundRobin(t *testing.T) {
+	a := &connection{endpoint: "a", isHealthy: true, weight: 3}
+	b := &connection{endpoint: "b", isHealthy: true, weight: 1}
+	down := &connection{endpoint: "down", isHealthy: false, weight: 5}
+	client := &Client{connections: []*connection{a, down, b}, balanceStrategy: RoundRobin}
+
+	counts := map[string]int{}
+	for i := 0; i < 40; i++ {
+		counts[client.getConnection().endpoint]++
+	}
+	if counts["a"] != 30 || counts["b"] != 10 || counts["down"] != 0 {
+		t.Errorf("Expected 30/10 split across healthy endpoints, got %v", counts)
+	}
+
+	client.balanceStrategy = Random
+	for i := 0; i < 20; i++ {
+		if conn := client.getConnection(); conn == down {
+			t.Fatal("Expected random selection to skip unhealthy endpoints")
+		}
+	}
+}
+
+func TestClient_EndpointTokens(t *testing.T) {
+	var mu sync.Mutex
+	auth := map[string]string{}
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			auth[name] = r.Header.Get("Authorization")
+			mu.Unlock()
+			w.Write([]byte(`{"text":"Success","code":0}`))
+		})
+	}
+	shared := httptest.NewServer(handler("shared"))
+	defer shared.Close()
+	own := httptest.NewServer(handler("own"))
+	defer own.Close()
+
+	client, err := NewClient(Config{
+		Endpoints:       []string{shared.URL},
+		EndpointConfigs: []EndpointConfig{{URL: own.URL, Token: "own-token"}},
+		Token:           "shared-token",
+		BalanceStrategy: "first_available",
+		BatchTimeout:    time.Second,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	client.SetToken("rotated-shared")
+	if err := client.SetEndpointToken(own.URL, "rotated-own"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := client.SetEndpointToken(shared.URL, "x"); err == nil {
+		t.Error("Expected error setting token of endpoint without its own token")
+	}
+	for _, conn := range client.connections {
+		conn.client.CheckHealth()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if auth["shared"] != "Splunk rotated-shared" || auth["own"] != "Splunk rotated-own" {
+		t.Errorf("Unexpected tokens %v", auth)
+	}
+}
+
+func TestTLSConfig_ClientConfig(t *testing.T) {
+	cfg, err := TLSConfig{}.ClientConfig(false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.InsecureSkipVerify || cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected verification and TLS 1.2 by default, got %+v", cfg)
+	}
+
+	cfg, err = TLSConfig{MinVersion: "1.3", ServerName: "splunk.internal"}.ClientConfig(false)
+	if err != nil || cfg.MinVersion != tls.VersionTLS13 || cfg.ServerName != "splunk.internal" {
+		t.Errorf("Expected TLS 1.3 with SNI override, got %+v (%v)", cfg, err)
+	}
+
+	fileCA, _, fileCert := writeClientCert(t, t.TempDir())
+	_, _, pemCert := writeClientCert(t, t.TempDir())
+	cfg, err = TLSConfig{
+		CAFile: fileCA,
+		CAPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pemCert.Raw})),
+	}.ClientConfig(false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, cert := range []*x509.Certificate{fileCert, pemCert} {
+		opts := x509.VerifyOptions{Roots: cfg.RootCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		if _, err := cert.Verify(opts); err != nil {
+			t.Errorf("Expected CAs from both CAFile and CAPEM to be trusted, got %v", err)
+		}
+	}
+
+	invalid := []TLSConfig{
+		{MinVersion: "1.4"},
+		{CAFile: fileCA, CAPEM: "not a certificate"},
+		{CAPEM: "not a certificate"},
+		{CAFile: "/nonexistent/ca.pem"},
+		{CertFile: "client.pem"},
+	}
+	for _, tc := range invalid {
+		if _, err := tc.ClientConfig(false); err == nil {
+			t.Errorf("Expected error for %+v, got nil", tc)
+		}
+	}
+}
+
+// writeClientCert creates a self-signed client certificate and key
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "whatthehec"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile, cert
+}
+
+func TestClient_MutualTLS(t *testing.T) {
+	certFile, keyFile, clientCert := writeClientCert(t, t.TempDir())
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	base := Config{
+		Endpoints:       []string{server.URL},
+		Token:           "test-token",
+		BalanceStrategy: "first_available",
+		BatchTimeout:    time.Second,
+	}
+
+	// Verification is on, so the test server's certificate is rejected
+	client, err := NewClient(base, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if client.connections[0].healthy() {
+		t.Error("Expected connection to an untrusted server to be unhealthy")
+	}
+
+	// Trusted CA but no client certificate
+	withCA := base
+	withCA.TLS = TLSConfig{CAPEM: caPEM}
+	client, _ = NewClient(withCA, nil, nil)
+	if client.connections[0].healthy() {
+		t.Error("Expected connection without a client certificate to be unhealthy")
+	}
+
+	withCert := withCA
+	withCert.TLS.CertFile = certFile
+	withCert.TLS.KeyFile = keyFile
+	client, _ = NewClient(withCert, nil, nil)
+	if !client.connections[0].healthy() {
+		t.Error("Expected mutual TLS connection to be healthy")
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	server := newTestServer(t, true)
+	client := newTestClient(t, server.URL)
+	endpoint := server.URL + "/services/collector"
+
+	if err := client.SendEvents(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if v := metrics.Default.Value("whatthehec_hec_events_total", endpoint, "sent"); v != 2 {
+		t.Errorf("Expected 2 sent events, got %v", v)
+	}
+	if v := metrics.Default.Value("whatthehec_hec_requests_total", endpoint, "200"); v != 1 {
+		t.Errorf("Expected 1 request, got %v", v)
+	}
+	if v := metrics.Default.Value("whatthehec_hec_request_duration_seconds", endpoint); v != 1 {
+		t.Errorf("Expected 1 latency observation, got %v", v)
+	}
+	if v := metrics.Default.Value("whatthehec_hec_endpoint_healthy", endpoint); v != 1 {
+		t.Errorf("Expected endpoint to be reported healthy, got %v", v)
+	}
+
+	unhealthy := newTestServer(t, false)
+	failure := &mockStorage{}
+	client, _ = NewClient(Config{Endpoints: []string{unhealthy.URL}, BalanceStrategy: "first_available"}, failure, nil)
+	before := metrics.Default.Value("whatthehec_hec_storage_events_total", "failure", "ok")
+	client.SendEvents(context.Background(), []*models.Event{{Event: "a"}})
+	if v := metrics.Default.Value("whatthehec_hec_storage_events_total", "failure", "ok") - before; v != 1 {
+		t.Errorf("Expected 1 event spilled to failure storage, got %v", v)
+	}
+}
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// recordSpans returns a function that reports the spans ended since the
+// call. The provider is installed once, as the package tracer delegates to
+// the first global provider.
+func recordSpans() func() []sdktrace.ReadOnlySpan {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	before := len(recorder.Ended())
+	return func() []sdktrace.ReadOnlySpan {
+		return recorder.Ended()[before:]
+	}
+}
+
+func TestClient_Tracing(t *testing.T) {
+	ended := recordSpans()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"text":"Invalid token","code":4}`))
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	if err := client.SendEvents(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}}); err == nil {
+		t.Fatal("Expected an error for a rejected token")
+	}
+
+	spans := ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "hec.send" {
+		t.Errorf("Expected span hec.send, got %s", span.Name())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("Expected error status, got %v", span.Status().Code)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if v := attrs["url.full"].AsString(); v != server.URL+"/services/collector" {
+		t.Errorf("Expected endpoint attribute, got %q", v)
+	}
+	if v := attrs["whatthehec.events"].AsInt64(); v != 2 {
+		t.Errorf("Expected 2 events, got %d", v)
+	}
+	if v := attrs["hec.code"].AsInt64(); v != 4 {
+		t.Errorf("Expected HEC code 4, got %d", v)
+	}
+	if v := attrs["http.response.status_code"].AsInt64(); v != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", v)
+	}
+	// A rejected token fails on every endpoint, so it is not retried
+	if v := attrs["hec.attempts"].AsInt64(); v != 1 {
+		t.Errorf("Expected 1 attempt, got %d", v)
+	}
+}
+
+// TestClient_ConcurrentSend is meant to be run with -race
+func TestClient_ConcurrentSend(t *testing.T) {
+	first := newTestServer(t, true)
+	second := newTestServer(t, true)
+
+	for _, strategy := range []string{"first_available", "sticky", "random", "roundrobin"} {
+		client, err := NewClient(Config{
+			Endpoints:       []string{first.URL, second.URL},
+			BalanceStrategy: strategy,
+			BatchTimeout:    time.Second,
+		}, &mockStorage{}, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if err := client.SendEvents(context.Background(), []*models.Event{{Event: "a"}}); err != nil {
+					t.Errorf("%s: expected no error, got %v", strategy, err)
+				}
+			}()
+			go func(conn *connection) {
+				defer wg.Done()
+				conn.updateHealth(context.Background())
+			}(client.connections[i%2])
+		}
+		wg.Wait()
+	}
+
+	if got := first.requests() + second.requests(); got != 80 {
+		t.Errorf("Expected 80 requests, got %d", got)
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	status, body := http.StatusServiceUnavailable, `{"text":"Server is busy","code":9}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	respond := func(s int, b string) {
+		mu.Lock()
+		status, body = s, b
+		mu.Unlock()
+	}
+
+	failure := &mockStorage{}
+	client, err := NewClient(Config{
+		Endpoints:        []string{server.URL},
+		BalanceStrategy:  "first_available",
+		BatchTimeout:     time.Second,
+		FailureThreshold: 2,
+		CircuitCooldown:  time.Minute,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+	conn := client.connections[0]
+	send := func() error {
+		return client.SendEvents(context.Background(), []*models.Event{{Event: "a"}})
+	}
+
+	// Rejected events do not count against the endpoint
+	respond(http.StatusBadRequest, `{"text":"Invalid data format","code":6}`)
+	send()
+	send()
+	if !conn.available() {
+		t.Fatal("Expected bad requests to leave the circuit closed")
+	}
+
+	// Failed sends are kept in failure storage
+	respond(http.StatusServiceUnavailable, `{"text":"Server is busy","code":9}`)
+	if err := send(); err != nil || !conn.available() {
+		t.Fatalf("Expected the circuit to stay closed below the threshold, got %v", err)
+	}
+	send()
+	if conn.available() {
+		t.Fatal("Expected the circuit to open at the threshold")
+	}
+	if err := send(); err != nil || failure.stored != 5 {
+		t.Errorf("Expected every undelivered event in failure storage, got %v (%d stored)", err, failure.stored)
+	}
+
+	// Half-open after the cooldown: a failure reopens the circuit
+	conn.mu.Lock()
+	conn.circuit.openUntil = time.Now()
+	conn.mu.Unlock()
+	if !conn.available() {
+		t.Fatal("Expected the circuit to be half-open after the cooldown")
+	}
+	send()
+	if conn.available() {
+		t.Fatal("Expected a half-open failure to reopen the circuit")
+	}
+
+	// A half-open success closes it
+	conn.mu.Lock()
+	conn.circuit.openUntil = time.Now()
+	conn.mu.Unlock()
+	respond(http.StatusOK, `{"text":"Success","code":0}`)
+	if err := send(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !conn.available() || conn.circuit.failures != 0 {
+		t.Error("Expected a half-open success to close the circuit")
+	}
+}
+
+// TestClient_HalfOpenProbe is meant to be run with -race
+func TestClient_HalfOpenProbe(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			mu.Lock()
+			posts++
+			mu.Unlock()
+			received <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+
+	failure := &mockStorage{}
+	client, err := NewClient(Config{
+		Endpoints:        []string{server.URL},
+		BatchTimeout:     time.Second,
+		FailureThreshold: 1,
+		CircuitCooldown:  time.Minute,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+	conn := client.connections[0]
+	conn.mu.Lock()
+	conn.circuit.open = true
+	conn.circuit.openUntil = time.Now()
+	conn.mu.Unlock()
+
+	const senders = 10
+	results := make(chan *Result, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			results <- client.Send(context.Background(), []*models.Event{{Event: "a"}})
+		}()
+	}
+
+	// Every send but the probe skips the endpoint while the probe runs
+	<-received
+	counts := map[Outcome]int{}
+	for i := 0; i < senders-1; i++ {
+		counts[(<-results).Events[0].Outcome]++
+	}
+	close(release)
+	counts[(<-results).Events[0].Outcome]++
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 1 {
+		t.Errorf("Expected 1 probe request, got %d", posts)
+	}
+	if counts[Delivered] != 1 || counts[Stored] != senders-1 {
+		t.Errorf("Expected 1 delivered and %d stored events, got %v", senders-1, counts)
+	}
+	if !conn.available() {
+		t.Error("Expected the successful probe to close the circuit")
+	}
+}
+
+func TestClient_StickyFallback(t *testing.T) {
+	first := newTestServer(t, true)
+	second := newTestServer(t, true)
+
+	client, err := NewClient(Config{
+		Endpoints:        []string{first.URL, second.URL},
+		BalanceStrategy:  "sticky",
+		BatchTimeout:     time.Second,
+		FailureThreshold: 1,
+		CircuitCooldown:  time.Minute,
+	}, &mockStorage{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+	client.connections[0].recordSend(errors.New("connection refused"))
+
+	for i := 0; i < 2; i++ {
+		if result := client.Send(context.Background(), []*models.Event{{Event: "a"}}); result.Count(Delivered) != 1 {
+			t.Fatalf("Expected the event to be delivered by the other endpoint, got %v", result.Err())
+		}
+	}
+	if first.requests() != 0 || second.requests() != 2 {
+		t.Errorf("Expected both sends to stick to the second endpoint, got %d and %d", first.requests(), second.requests())
+	}
+
+	// The sticky endpoint moves on once StickyTTL has passed
+	client.connections[0].recordSend(nil)
+	client.config.StickyTTL = time.Minute
+	client.mu.Lock()
+	client.stickySince = time.Now().Add(-time.Minute)
+	client.mu.Unlock()
+	if conn := client.getSticky(); conn != client.connections[0] {
+		t.Errorf("Expected the first endpoint after StickyTTL, got %s", conn.endpoint)
+	}
+	if conn := client.getSticky(); conn != client.connections[0] {
+		t.Errorf("Expected the first endpoint to stick within StickyTTL, got %s", conn.endpoint)
+	}
+}
+
+func TestClient_CircuitCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			<-release
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewClient(Config{
+		Endpoints:        []string{server.URL},
+		BatchTimeout:     time.Second,
+		FailureThreshold: 1,
+		CircuitCooldown:  time.Minute,
+	}, &mockStorage{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+	conn := client.connections[0]
+	send := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		client.Send(ctx, []*models.Event{{Event: "a"}})
+	}
+
+	send()
+	if !conn.available() {
+		t.Error("Expected a send cancelled by the caller to leave the circuit closed")
+	}
+
+	// A cancelled probe is released for the next send
+	conn.mu.Lock()
+	conn.circuit.open = true
+	conn.circuit.openUntil = time.Now()
+	conn.mu.Unlock()
+	send()
+	if !conn.available() {
+		t.Error("Expected a cancelled probe to leave the circuit half-open")
+	}
+}
+
+func TestClient_Close(t *testing.T) {
+	var mu sync.Mutex
+	probes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			probes++
+			mu.Unlock()
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return probes
+	}
+
+	client, err := NewClient(Config{
+		Endpoints:           []string{server.URL},
+		BatchTimeout:        time.Second,
+		HealthCheckInterval: 10 * time.Millisecond,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if count() < 2 {
+		t.Errorf("Expected periodic health checks, got %d", count())
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Close cancels a probe in flight, which the server may still see
+	time.Sleep(20 * time.Millisecond)
+	closed := count()
+	time.Sleep(50 * time.Millisecond)
+	if count() != closed {
+		t.Errorf("Expected health checks to stop on Close, got %d more", count()-closed)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			received <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	failure := &flushStorage{}
+	client, err := NewClient(Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := []*models.Event{{Event: "a"}, {Event: "b"}}
+	go client.SendEvents(context.Background(), events)
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "2 events still being sent") {
+		t.Errorf("Expected error to report 2 events in flight, got %v", err)
+	}
+	if !failure.flushed || !failure.closed {
+		t.Error("Expected failure storage to be flushed and closed")
+	}
+
+	if err := client.SendEvents(context.Background(), events); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Shutdown, got %v", err)
+	}
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error on second Shutdown, got %v", err)
+	}
+}
+
+func TestClient_ShutdownHealthCheck(t *testing.T) {
+	var mu sync.Mutex
+	probes := 0
+	probing := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		probes++
+		first := probes == 1
+		mu.Unlock()
+		// Every probe after the one in NewClient hangs
+		if !first {
+			select {
+			case probing <- struct{}{}:
+			default:
+			}
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer server.Close()
+
+	// No HTTP timeout, so only Shutdown can end the hanging probe
+	client, err := NewClient(Config{
+		Endpoints:           []string{server.URL},
+		HealthCheckInterval: 10 * time.Millisecond,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	<-probing
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Errorf("Expected the hanging health check to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected Shutdown to stop the health check at once, took %v", elapsed)
+	}
+}
+
+func TestClient_Failover(t *testing.T) {
+	ended := recordSpans()
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer busy.Close()
+	healthy := newTestServer(t, true)
+
+	client, err := NewClient(Config{
+		Endpoints:       []string{busy.URL, healthy.URL},
+		BalanceStrategy: "first_available",
+		BatchTimeout:    time.Second,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	result := client.Send(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}})
+	if err := result.Err(); err != nil {
+		t.Fatalf("Expected the next endpoint to accept the events, got %v", err)
+	}
+	if result.Count(Delivered) != 2 || healthy.requests() != 1 {
+		t.Errorf("Expected 2 events delivered in 1 request, got %d in %d", result.Count(Delivered), healthy.requests())
+	}
+	if len(result.Responses) != 2 || result.Responses[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the busy and successful responses, got %+v", result.Responses)
+	}
+	if v := metrics.Default.Value("whatthehec_hec_retries_total", busy.URL+"/services/collector"); v != 1 {
+		t.Errorf("Expected 1 retry after the busy endpoint, got %v", v)
+	}
+
+	// Sends left running by other tests may end during this one
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range ended() {
+		for _, attr := range span.Attributes() {
+			if attr.Key == "url.full" && attr.Value.AsString() == healthy.URL+"/services/collector" {
+				spans = append(spans, span)
+			}
+		}
+	}
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == "hec.attempts" && attr.Value.AsInt64() != 2 {
+			t.Errorf("Expected 2 attempts, got %d", attr.Value.AsInt64())
+		}
+	}
+	if events := spans[0].Events(); len(events) != 1 || events[0].Name != "hec.attempt" {
+		t.Errorf("Expected the failed attempt as a span event, got %v", events)
+	}
+}
+
+func TestClient_SendContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			<-release
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(t, server.URL)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.SendEvents(ctx, []*models.Event{{Event: "a"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the send to stop at the deadline, took %v", elapsed)
+	}
+}
@@ -0,0 +1,189 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+// Sender delivers events to HEC. It is implemented by Client and FanOut.
+type Sender interface {
+	SendEvents(ctx context.Context, events []*models.Event) error
+	Send(ctx context.Context, events []*models.Event) *Result
+	Flush(ctx context.Context) error
+	// Shutdown stops accepting events and writes out what it holds,
+	// within ctx
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// Fan-out delivery modes
+const (
+	FanOutAll        = "all"         // every destination must accept the events
+	FanOutBestEffort = "best_effort" // succeed if any destination accepts them
+)
+
+// Destination is one independent HEC deployment in a FanOut
+type Destination struct {
+	Name   string
+	Client *Client
+	// Filter selects the events sent to this destination, nil sends all
+	Filter func(*models.Event) bool
+}
+
+// FanOut sends the same events to several destinations in parallel. Each
+// destination's Client has its own endpoints, balancing and failure
+// storage; cold storage is written once by the FanOut.
+type FanOut struct {
+	mode         string
+	destinations []Destination
+	coldStorage  storage.StorageBackend
+	life         lifecycle
+}
+
+// NewFanOut creates a multi-destination sender
+func NewFanOut(mode string, destinations []Destination, coldStorage storage.StorageBackend) (*FanOut, error) {
+	switch mode {
+	case "":
+		mode = FanOutAll
+	case FanOutAll, FanOutBestEffort:
+	default:
+		return nil, fmt.Errorf("unknown fan-out mode %q", mode)
+	}
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("no HEC destinations configured")
+	}
+
+	return &FanOut{
+		mode:         mode,
+		destinations: destinations,
+		coldStorage:  coldStorage,
+	}, nil
+}
+
+// SendEvents sends events to every destination. In FanOutAll mode any
+// failure is returned; in FanOutBestEffort mode failures are logged and an
+// error is only returned when no destination accepted the events. See Send.
+func (f *FanOut) SendEvents(ctx context.Context, events []*models.Event) error {
+	return f.Send(ctx, events).Err()
+}
+
+// Send sends events to every destination and reports the outcome of each
+// event. In FanOutAll mode an event takes the worst outcome among the
+// destinations that selected it; in FanOutBestEffort mode it takes the
+// best. Events that no destination selects count as delivered.
+func (f *FanOut) Send(ctx context.Context, events []*models.Event) *Result {
+	if !f.life.begin(len(events)) {
+		return newResult(len(events), Dropped, ErrClosed)
+	}
+	defer f.life.end(len(events))
+
+	var coldErr error
+	if f.coldStorage != nil {
+		coldErr = f.coldStorage.Store(ctx, events)
+		observeStorage("cold", len(events), coldErr)
+		if coldErr != nil {
+			slog.WarnContext(ctx, "Failed to send events to cold storage", "count", len(events), "error", coldErr)
+		}
+	}
+
+	results := make([]*Result, len(f.destinations))
+	indexes := make([][]int, len(f.destinations))
+	var wg sync.WaitGroup
+	for i, dest := range f.destinations {
+		selected, index := filterEvents(events, dest.Filter)
+		if len(selected) == 0 {
+			continue
+		}
+		indexes[i] = index
+		wg.Add(1)
+		go func(i int, dest Destination) {
+			defer wg.Done()
+			results[i] = dest.Client.Send(ctx, selected)
+		}(i, dest)
+	}
+	wg.Wait()
+
+	result := newResult(len(events), 0, nil)
+	result.ColdStorage = coldErr
+	failed := false
+	for i, dest := range f.destinations {
+		if results[i] == nil {
+			continue
+		}
+		for j, r := range results[i].Events {
+			if r.Err != nil {
+				r.Err = fmt.Errorf("destination %s: %w", dest.Name, r.Err)
+			}
+			if r.Outcome == Dropped {
+				failed = true
+			}
+			merged := &result.Events[indexes[i][j]]
+			if merged.Outcome == 0 ||
+				(f.mode == FanOutAll && r.Outcome > merged.Outcome) ||
+				(f.mode == FanOutBestEffort && r.Outcome < merged.Outcome) {
+				*merged = r
+			}
+		}
+		for _, resp := range results[i].Responses {
+			resp.Destination = dest.Name
+			result.Responses = append(result.Responses, resp)
+		}
+	}
+	for i := range result.Events {
+		if result.Events[i].Outcome == 0 {
+			result.Events[i].Outcome = Delivered
+		}
+	}
+
+	if failed && f.mode == FanOutBestEffort && result.Count(Dropped) < len(events) {
+		slog.WarnContext(ctx, "Delivery failed for some destinations", "error", errorsOf(results))
+	}
+	return result
+}
+
+// errorsOf joins the errors of results
+func errorsOf(results []*Result) error {
+	var errs []error
+	for _, r := range results {
+		if r != nil {
+			errs = append(errs, r.Err())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// filterEvents returns the events selected by filter and their indexes in
+// events
+func filterEvents(events []*models.Event, filter func(*models.Event) bool) ([]*models.Event, []int) {
+	selected := make([]*models.Event, 0, len(events))
+	index := make([]int, 0, len(events))
+	for i, event := range events {
+		if filter == nil || filter(event) {
+			selected = append(selected, event)
+			index = append(index, i)
+		}
+	}
+	return selected, index
+}
+
+// Flush flushes cold storage and every destination's failure storage
+func (f *FanOut) Flush(ctx context.Context) error {
+	var errs []error
+	if flusher, ok := f.coldStorage.(storage.Flusher); ok {
+		if err := flusher.Flush(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, dest := range f.destinations {
+		if err := dest.Client.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", dest.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
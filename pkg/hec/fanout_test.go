@@ -0,0 +1,143 @@
+package hec
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// testServer is a HEC server that counts received requests
+type testServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events int
+}
+
+func newTestServer(t *testing.T, healthy bool) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/services/collector" {
+			s.mu.Lock()
+			s.events++
+			s.mu.Unlock()
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	client, err := NewClient(Config{
+		Endpoints:       []string{url},
+		Token:           "test-token",
+		BalanceStrategy: "first_available",
+		BatchTimeout:    time.Second,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return client
+}
+
+func TestFanOut_Modes(t *testing.T) {
+	security := newTestServer(t, true)
+	ops := newTestServer(t, false)
+	events := []*models.Event{{Event: "test"}}
+
+	destinations := []Destination{
+		{Name: "security", Client: newTestClient(t, security.URL)},
+		{Name: "ops", Client: newTestClient(t, ops.URL)},
+	}
+
+	all, err := NewFanOut(FanOutAll, destinations, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := all.SendEvents(context.Background(), events); err == nil {
+		t.Error("Expected error when one destination fails in all mode")
+	}
+	if security.requests() != 1 {
+		t.Errorf("Expected healthy destination to receive events, got %d requests", security.requests())
+	}
+
+	bestEffort, _ := NewFanOut(FanOutBestEffort, destinations, nil)
+	if err := bestEffort.SendEvents(context.Background(), events); err != nil {
+		t.Errorf("Expected no error in best effort mode, got %v", err)
+	}
+
+	onlyOps, _ := NewFanOut(FanOutBestEffort, destinations[1:], nil)
+	if err := onlyOps.SendEvents(context.Background(), events); err == nil {
+		t.Error("Expected error when every destination fails in best effort mode")
+	}
+}
+
+func TestFanOut_Filter(t *testing.T) {
+	security := newTestServer(t, true)
+	ops := newTestServer(t, true)
+	cold := &mockStorage{}
+
+	fanOut, err := NewFanOut(FanOutAll, []Destination{
+		{
+			Name:   "security",
+			Client: newTestClient(t, security.URL),
+			Filter: func(e *models.Event) bool { return e.Field("loggroup") == "/aws/cloudtrail" },
+		},
+		{Name: "ops", Client: newTestClient(t, ops.URL)},
+	}, cold)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := []*models.Event{
+		{Event: "app log", Metadata: map[string]string{"loggroup": "/aws/lambda/app"}},
+	}
+	if err := fanOut.SendEvents(context.Background(), events); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if security.requests() != 0 || ops.requests() != 1 {
+		t.Errorf("Expected only ops to receive the event, got security=%d ops=%d", security.requests(), ops.requests())
+	}
+	if cold.stored != 1 {
+		t.Errorf("Expected cold storage to receive every event once, got %d", cold.stored)
+	}
+}
+
+func TestNewFanOut_Invalid(t *testing.T) {
+	if _, err := NewFanOut("most", []Destination{{Name: "a"}}, nil); err == nil {
+		t.Error("Expected error for unknown mode, got nil")
+	}
+	if _, err := NewFanOut(FanOutAll, nil, nil); err == nil {
+		t.Error("Expected error for no destinations, got nil")
+	}
+}
+
+// mockStorage counts stored events
+type mockStorage struct {
+	mu     sync.Mutex
+	stored int
+}
+
+func (m *mockStorage) Store(ctx context.Context, events []*models.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stored += len(events)
+	return nil
+}
+
+func (m *mockStorage) Close() error { return nil }
@@ -0,0 +1,165 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mosajjal/Go-Splunk-HTTP/splunk/v2"
+)
+
+// Health check defaults
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultCircuitCooldown     = 30 * time.Second
+)
+
+// circuit tracks the send results of one endpoint. After threshold
+// consecutive failures it opens and the endpoint is not selected until the
+// cooldown has passed. The endpoint is then half-open: a single send, the
+// probe, is let through, and closes the circuit if it succeeds or opens it
+// for another cooldown if it fails.
+type circuit struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	open      bool
+	openUntil time.Time
+	probing   bool // a half-open probe is in flight
+}
+
+// healthCheck probes conn every health check interval until Close
+func (c *Client) healthCheck(conn *connection) {
+	defer c.wg.Done()
+
+	interval := c.config.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			conn.updateHealth(c.ctx)
+		}
+	}
+}
+
+// updateHealth probes the endpoint, unless ctx ends first
+func (c *connection) updateHealth(ctx context.Context) {
+	err := c.checkHealth(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	c.setHealthy(err == nil)
+}
+
+// healthy reports whether the last health check succeeded
+func (c *connection) healthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isHealthy
+}
+
+func (c *connection) setHealthy(healthy bool) {
+	c.mu.Lock()
+	c.isHealthy = healthy
+	c.mu.Unlock()
+
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	endpointHealthy.Set(value, c.endpoint)
+}
+
+// available reports whether the connection may be selected: its last
+// health check succeeded and its circuit is closed, or half-open with no
+// probe in flight
+func (c *connection) available() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isHealthy && (!c.circuit.open || c.circuit.halfOpen(time.Now()))
+}
+
+// acquire claims the connection for a send. It fails if the circuit is
+// open, or half-open and another send already holds the probe.
+func (c *connection) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.circuit.open {
+		return true
+	}
+	if !c.circuit.halfOpen(time.Now()) {
+		return false
+	}
+	c.circuit.probing = true
+	return true
+}
+
+// halfOpen reports whether an open circuit may let a probe through.
+// Callers must hold the connection's mu.
+func (c *circuit) halfOpen(now time.Time) bool {
+	return !c.probing && !now.Before(c.openUntil)
+}
+
+// recordSend updates the circuit with the result of a send
+func (c *connection) recordSend(err error) {
+	if c.circuit.threshold <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	wasOpen := c.circuit.open
+	c.circuit.probing = false
+	if err == nil || !endpointFailure(err) {
+		c.circuit.failures = 0
+		c.circuit.open = false
+	} else {
+		c.circuit.failures++
+		// A failure while half-open reopens the circuit straight away
+		if wasOpen || c.circuit.failures >= c.circuit.threshold {
+			c.circuit.open = true
+			c.circuit.openUntil = time.Now().Add(c.circuit.cooldown)
+		}
+	}
+	isOpen := c.circuit.open
+	failures := c.circuit.failures
+	c.mu.Unlock()
+
+	switch {
+	case isOpen && !wasOpen:
+		circuitOpen.Set(1, c.endpoint)
+		slog.Warn("HEC endpoint circuit opened", "endpoint", c.endpoint, "failures", failures, "error", err)
+	case !isOpen && wasOpen:
+		circuitOpen.Set(0, c.endpoint)
+		slog.Info("HEC endpoint circuit closed", "endpoint", c.endpoint)
+	}
+}
+
+// abandon releases a send whose result is not recorded, such as one the
+// caller cancelled, letting another send take the half-open probe
+func (c *connection) abandon() {
+	c.mu.Lock()
+	c.circuit.probing = false
+	c.mu.Unlock()
+}
+
+// endpointFailure reports whether a send error reflects on the endpoint
+// rather than on the events. HEC rejects malformed events with HTTP 400,
+// which no other endpoint would accept either.
+func endpointFailure(err error) bool {
+	var resp *splunk.EventCollectorResponse
+	if errors.As(err, &resp) {
+		if status, codeErr := resp.Code.HTTPCode(); codeErr == nil && status == http.StatusBadRequest {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package hec
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+)
+
+// HEC metrics, registered in metrics.Default
+var (
+	eventsTotal = metrics.Default.Counter("whatthehec_hec_events_total",
+		"Events sent to HEC, by endpoint and result (sent or failed)", "endpoint", "result")
+	requestsTotal = metrics.Default.Counter("whatthehec_hec_requests_total",
+		"HEC requests, by endpoint and HTTP status code (error if no response)", "endpoint", "code")
+	requestBytes = metrics.Default.Counter("whatthehec_hec_request_bytes_total",
+		"Bytes of HEC request bodies sent, by endpoint", "endpoint")
+	requestDuration = metrics.Default.Histogram("whatthehec_hec_request_duration_seconds",
+		"HEC request latency, by endpoint", metrics.DefaultBuckets, "endpoint")
+	retriesTotal = metrics.Default.Counter("whatthehec_hec_retries_total",
+		"Batches sent again to the next endpoint after a failed send, by the endpoint that failed", "endpoint")
+	endpointHealthy = metrics.Default.Gauge("whatthehec_hec_endpoint_healthy",
+		"Whether the last health check of an endpoint succeeded (1) or failed (0)", "endpoint")
+	circuitOpen = metrics.Default.Gauge("whatthehec_hec_circuit_open",
+		"Whether an endpoint's circuit is open (1) after consecutive failed sends", "endpoint")
+	storageEventsTotal = metrics.Default.Counter("whatthehec_hec_storage_events_total",
+		"Events written to failure or cold storage, by storage and result (ok or error)", "storage", "result")
+)
+
+// metricsTransport records the size, latency and status of event requests
+type metricsTransport struct {
+	base     http.RoundTripper
+	endpoint string
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	requestDuration.Observe(time.Since(start).Seconds(), t.endpoint)
+	if req.ContentLength > 0 {
+		requestBytes.Add(float64(req.ContentLength), t.endpoint)
+	}
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	requestsTotal.Inc(t.endpoint, code)
+	return resp, err
+}
+
+// observeStorage records events written to failure or cold storage
+func observeStorage(name string, events int, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	storageEventsTotal.Add(float64(events), name, result)
+}
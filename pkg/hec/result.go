@@ -0,0 +1,170 @@
+package hec
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mosajjal/Go-Splunk-HTTP/splunk/v2"
+)
+
+// Outcome is what happened to one event in a send
+type Outcome uint8
+
+// Event outcomes, from best to worst. Stored means the failure storage
+// accepted the events; a buffered backend holds them in memory until it
+// uploads them and reports failed uploads from Flush and Shutdown, after
+// which Unstore corrects the result.
+const (
+	Delivered Outcome = iota + 1 // accepted by HEC
+	Stored                       // written to failure storage instead
+	Dropped                      // neither delivered nor stored
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Delivered:
+		return "delivered"
+	case Stored:
+		return "stored"
+	case Dropped:
+		return "dropped"
+	}
+	return fmt.Sprintf("Outcome(%d)", uint8(o))
+}
+
+// EventResult is the outcome of one event
+type EventResult struct {
+	Outcome Outcome
+	// Err is why the event was not delivered, also when it was stored; nil
+	// if it was delivered
+	Err error
+}
+
+// Response is a HEC response to one request
+type Response struct {
+	Destination string // set by FanOut
+	Endpoint    string
+	StatusCode  int // HTTP status code
+	Code        int // HEC status code, e.g. 6 for invalid data format
+	Text        string
+	// InvalidEvent is the index in the request of the event HEC rejected,
+	// or -1
+	InvalidEvent int
+}
+
+// Result reports what happened to each event passed to Send, so callers
+// can retry or report only the events that were dropped
+type Result struct {
+	// Events holds the outcome of each event, in the order they were passed
+	Events []EventResult
+	// Responses holds the HEC responses received, one per request
+	Responses []Response
+	// ColdStorage is the error writing the events to cold storage. It does
+	// not affect the outcome of the events.
+	ColdStorage error
+}
+
+// newResult creates a result with every event set to outcome
+func newResult(events int, outcome Outcome, err error) *Result {
+	r := &Result{Events: make([]EventResult, events)}
+	r.set(0, events, outcome, err)
+	return r
+}
+
+// set records the outcome of events[start:end]
+func (r *Result) set(start, end int, outcome Outcome, err error) {
+	for i := start; i < end; i++ {
+		r.Events[i] = EventResult{Outcome: outcome, Err: err}
+	}
+}
+
+// ErrNotStored is wrapped by the Flush error when failure storage did not
+// write events it accepted, so that events reported Stored may be lost
+var ErrNotStored = errors.New("failure storage did not write events")
+
+// Unstore marks the stored events dropped with err, for when a Flush after
+// the send fails with ErrNotStored. Events that were not delivered keep
+// the send error as well.
+func (r *Result) Unstore(err error) {
+	for i, e := range r.Events {
+		if e.Outcome == Stored {
+			r.Events[i] = EventResult{Outcome: Dropped, Err: errors.Join(e.Err, err)}
+		}
+	}
+}
+
+// Count returns the number of events with outcome
+func (r *Result) Count(outcome Outcome) int {
+	n := 0
+	for _, e := range r.Events {
+		if e.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// Dropped returns the indexes of the events that were neither delivered
+// nor stored
+func (r *Result) Dropped() []int {
+	var dropped []int
+	for i, e := range r.Events {
+		if e.Outcome == Dropped {
+			dropped = append(dropped, i)
+		}
+	}
+	return dropped
+}
+
+// Err returns the distinct errors of the dropped events, or nil if every
+// event was delivered or stored
+func (r *Result) Err() error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, e := range r.Events {
+		if e.Outcome != Dropped || e.Err == nil || seen[e.Err.Error()] {
+			continue
+		}
+		seen[e.Err.Error()] = true
+		errs = append(errs, e.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// sendResult builds the result of sending events to endpoint. HEC indexes
+// the events of a request in order and stops at the first invalid one, so
+// the events before it are delivered.
+func sendResult(endpoint string, events int, err error) *Result {
+	if err == nil {
+		r := newResult(events, Delivered, nil)
+		r.Responses = []Response{{
+			Endpoint:     endpoint,
+			StatusCode:   http.StatusOK,
+			Text:         "Success",
+			InvalidEvent: -1,
+		}}
+		return r
+	}
+
+	r := newResult(events, Dropped, err)
+	var resp *splunk.EventCollectorResponse
+	if !errors.As(err, &resp) {
+		return r
+	}
+	response := Response{
+		Endpoint:     endpoint,
+		Code:         int(resp.Code),
+		Text:         resp.Text,
+		InvalidEvent: -1,
+	}
+	if status, codeErr := resp.Code.HTTPCode(); codeErr == nil {
+		response.StatusCode = status
+	}
+	if n := resp.InvalidEventNumber; n != nil && *n >= 0 && *n < events {
+		response.InvalidEvent = *n
+		r.set(0, *n, Delivered, nil)
+	}
+	r.Responses = []Response{response}
+	return r
+}
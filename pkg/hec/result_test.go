@@ -0,0 +1,237 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+func TestClient_SendResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"text":"Invalid data format","code":6,"invalid-event-number":1}`))
+			return
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+	defer client.Close()
+
+	result := client.Send(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}, {Event: "c"}})
+	want := []Outcome{Delivered, Dropped, Dropped}
+	for i, e := range result.Events {
+		if e.Outcome != want[i] {
+			t.Errorf("Expected event %d to be %v, got %v", i, want[i], e.Outcome)
+		}
+	}
+	if dropped := result.Dropped(); len(dropped) != 2 || dropped[0] != 1 {
+		t.Errorf("Expected events 1 and 2 to be dropped, got %v", dropped)
+	}
+	if result.Err() == nil {
+		t.Error("Expected error for dropped events, got nil")
+	}
+
+	if len(result.Responses) != 1 {
+		t.Fatalf("Expected 1 response, got %d", len(result.Responses))
+	}
+	resp := result.Responses[0]
+	if resp.StatusCode != http.StatusBadRequest || resp.Code != 6 || resp.Text != "Invalid data format" || resp.InvalidEvent != 1 {
+		t.Errorf("Expected invalid data format response for event 1, got %+v", resp)
+	}
+}
+
+func TestClient_SendResult_Stored(t *testing.T) {
+	server := newTestServer(t, false)
+	failure := &mockStorage{}
+	client, err := NewClient(Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	result := client.Send(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}})
+	if result.Count(Stored) != 2 {
+		t.Errorf("Expected 2 stored events, got %d", result.Count(Stored))
+	}
+	if err := result.Err(); err != nil {
+		t.Errorf("Expected no error for stored events, got %v", err)
+	}
+}
+
+func TestFanOut_SendResult(t *testing.T) {
+	security := newTestServer(t, true)
+	ops := newTestServer(t, false)
+
+	destinations := []Destination{
+		{
+			Name:   "security",
+			Client: newTestClient(t, security.URL),
+			Filter: func(e *models.Event) bool { return e.Field("loggroup") == "/aws/cloudtrail" },
+		},
+		{
+			Name:   "ops",
+			Client: newTestClient(t, ops.URL),
+			Filter: func(e *models.Event) bool { return e.Field("loggroup") == "/aws/lambda/app" },
+		},
+	}
+	events := []*models.Event{
+		{Event: "trail", Metadata: map[string]string{"loggroup": "/aws/cloudtrail"}},
+		{Event: "app", Metadata: map[string]string{"loggroup": "/aws/lambda/app"}},
+		{Event: "other", Metadata: map[string]string{"loggroup": "/aws/other"}},
+	}
+
+	for _, mode := range []string{FanOutAll, FanOutBestEffort} {
+		fanOut, err := NewFanOut(mode, destinations, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		result := fanOut.Send(context.Background(), events)
+		want := []Outcome{Delivered, Dropped, Delivered}
+		for i, e := range result.Events {
+			if e.Outcome != want[i] {
+				t.Errorf("%s: expected event %d to be %v, got %v", mode, i, want[i], e.Outcome)
+			}
+		}
+		if len(result.Responses) != 1 || result.Responses[0].Destination != "security" {
+			t.Errorf("%s: expected a response from security, got %+v", mode, result.Responses)
+		}
+	}
+}
+
+func TestClient_SendResult_Spill(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+	}))
+	defer server.Close()
+	failure := &mockStorage{}
+	client, err := NewClient(Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	result := client.Send(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}})
+	if result.Count(Stored) != 2 || failure.stored != 2 {
+		t.Errorf("Expected 2 stored events after a 503, got %d (%d in storage)", result.Count(Stored), failure.stored)
+	}
+	if result.Events[0].Err == nil {
+		t.Error("Expected stored events to keep the send error")
+	}
+	if err := result.Err(); err != nil {
+		t.Errorf("Expected no error for stored events, got %v", err)
+	}
+}
+
+// ctxStorage fails to store when its context has ended
+type ctxStorage struct {
+	mockStorage
+}
+
+func (c *ctxStorage) Store(ctx context.Context, events []*models.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.mockStorage.Store(ctx, events)
+}
+
+func TestClient_SendResult_SpillCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			<-release
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer server.Close()
+	defer close(release)
+	failure := &ctxStorage{}
+	client, err := NewClient(Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	result := client.Send(ctx, []*models.Event{{Event: "a"}, {Event: "b"}})
+	if result.Count(Stored) != 2 || failure.stored != 2 {
+		t.Errorf("Expected 2 stored events after the caller's deadline, got %d (%d in storage)", result.Count(Stored), failure.stored)
+	}
+
+	// With no endpoint left to try
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.connections[0].setHealthy(false)
+	if result := client.Send(cancelled, []*models.Event{{Event: "c"}}); result.Count(Stored) != 1 {
+		t.Errorf("Expected the event to be stored with a cancelled context, got %v", result.Err())
+	}
+}
+
+// failingStorage never writes events
+type failingStorage struct{}
+
+func (failingStorage) Store(ctx context.Context, events []*models.Event) error {
+	return errors.New("upload failed")
+}
+
+func (failingStorage) Close() error { return nil }
+
+func TestClient_SendResult_Unstore(t *testing.T) {
+	server := newTestServer(t, false)
+	failure := storage.NewBufferedStorage(failingStorage{}, storage.BufferConfig{})
+	cold := storage.NewBufferedStorage(failingStorage{}, storage.BufferConfig{})
+	client, err := NewClient(Config{
+		Endpoints:    []string{server.URL},
+		BatchTimeout: time.Second,
+	}, failure, cold)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	// The buffer accepts the events before the upload fails
+	result := client.Send(context.Background(), []*models.Event{{Event: "a"}, {Event: "b"}})
+	if result.Count(Stored) != 2 {
+		t.Fatalf("Expected 2 stored events, got %d", result.Count(Stored))
+	}
+	err = client.Flush(context.Background())
+	if !errors.Is(err, ErrNotStored) {
+		t.Fatalf("Expected ErrNotStored from Flush, got %v", err)
+	}
+	result.Unstore(err)
+	if dropped := result.Dropped(); len(dropped) != 2 {
+		t.Errorf("Expected both events dropped, got %v", dropped)
+	}
+	if !errors.Is(result.Err(), ErrNotStored) {
+		t.Errorf("Expected the flush error in the result, got %v", result.Err())
+	}
+
+	// Cold storage does not decide the outcome of events
+	client.failureStorage = &mockStorage{}
+	client.Send(context.Background(), []*models.Event{{Event: "c"}})
+	if err := client.Flush(context.Background()); err == nil || errors.Is(err, ErrNotStored) {
+		t.Errorf("Expected a cold storage error only, got %v", err)
+	}
+}
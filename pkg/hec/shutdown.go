@@ -0,0 +1,131 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+// ErrClosed is returned by SendEvents after Shutdown or Close
+var ErrClosed = errors.New("hec sender is shut down")
+
+// lifecycle tracks the sends in progress so that a shutdown can wait for
+// them to finish
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool
+	inflight int // events in running sends, guarded by mu
+	sends    sync.WaitGroup
+}
+
+// begin registers a send of events, returning false once shut down
+func (l *lifecycle) begin(events int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.inflight += events
+	l.sends.Add(1)
+	return true
+}
+
+// end marks a send started with begin as finished
+func (l *lifecycle) end(events int) {
+	l.mu.Lock()
+	l.inflight -= events
+	l.mu.Unlock()
+	l.sends.Done()
+}
+
+// close stops new sends and waits for the running ones until ctx ends. It
+// returns false if the lifecycle was already closed.
+func (l *lifecycle) close(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return false, nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		l.sends.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return true, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		inflight := l.inflight
+		l.mu.Unlock()
+		return true, fmt.Errorf("timed out with %d events still being sent: %w", inflight, ctx.Err())
+	}
+}
+
+// Shutdown stops accepting events, waits for running sends, stops the
+// health checks and writes out and closes the failure and cold storage,
+// giving up when ctx ends. The error reports what could not be delivered.
+// Calling Shutdown again does nothing.
+func (c *Client) Shutdown(ctx context.Context) error {
+	first, err := c.life.close(ctx)
+	if !first {
+		return nil
+	}
+	errs := []error{err}
+
+	c.stop()
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("timed out stopping health checks: %w", ctx.Err()))
+	}
+
+	if err := storage.Shutdown(ctx, c.coldStorage); err != nil {
+		errs = append(errs, fmt.Errorf("cold storage: %w", err))
+	}
+	if err := storage.Shutdown(ctx, c.failureStorage); err != nil {
+		errs = append(errs, fmt.Errorf("failure storage: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Close shuts the client down without a deadline
+func (c *Client) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting events, waits for running sends and shuts down
+// every destination and the cold storage, giving up when ctx ends
+func (f *FanOut) Shutdown(ctx context.Context) error {
+	first, err := f.life.close(ctx)
+	if !first {
+		return nil
+	}
+	errs := []error{err}
+
+	for _, dest := range f.destinations {
+		if err := dest.Client.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", dest.Name, err))
+		}
+	}
+	if err := storage.Shutdown(ctx, f.coldStorage); err != nil {
+		errs = append(errs, fmt.Errorf("cold storage: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Close shuts the fan-out down without a deadline
+func (f *FanOut) Close() error {
+	return f.Shutdown(context.Background())
+}
@@ -0,0 +1,94 @@
+package hec
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig holds TLS settings for HEC connections. Certificate
+// verification is controlled separately by TLSSkipVerify.
+type TLSConfig struct {
+	CAFile     string // PEM CA bundle file, added to the system roots
+	CAPEM      string // PEM CA bundle contents, added to the system roots and CAFile
+	CertFile   string // client certificate for mutual TLS
+	KeyFile    string // client key for mutual TLS
+	MinVersion string // 1.0, 1.1, 1.2 or 1.3, defaults to 1.2
+	ServerName string // overrides SNI and the name the certificate is verified against
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// merge returns t with empty fields taken from defaults
+func (t TLSConfig) merge(defaults TLSConfig) TLSConfig {
+	if t.CAFile == "" && t.CAPEM == "" {
+		t.CAFile, t.CAPEM = defaults.CAFile, defaults.CAPEM
+	}
+	if t.CertFile == "" && t.KeyFile == "" {
+		t.CertFile, t.KeyFile = defaults.CertFile, defaults.KeyFile
+	}
+	if t.MinVersion == "" {
+		t.MinVersion = defaults.MinVersion
+	}
+	if t.ServerName == "" {
+		t.ServerName = defaults.ServerName
+	}
+	return t
+}
+
+// ClientConfig builds a tls.Config, loading the CA bundle and client
+// certificate
+func (t TLSConfig) ClientConfig(skipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+	}
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", t.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if t.CAFile != "" || t.CAPEM != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if t.CAFile != "" {
+			pem, err := os.ReadFile(t.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CAFile)
+			}
+		}
+		if t.CAPEM != "" && !pool.AppendCertsFromPEM([]byte(t.CAPEM)) {
+			return nil, fmt.Errorf("no certificates found in CA bundle")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
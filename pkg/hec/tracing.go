@@ -0,0 +1,56 @@
+package hec
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/mosajjal/Go-Splunk-HTTP/splunk/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/mosajjal/whatthehec/pkg/hec")
+
+// startSendSpan starts the span of delivering events to HEC, which covers
+// every endpoint tried
+func startSendSpan(ctx context.Context, events int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "hec.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("whatthehec.events", events)))
+}
+
+// recordAttempt adds a failed POST to endpoint to the span
+func recordAttempt(span trace.Span, endpoint string, err error) {
+	span.AddEvent("hec.attempt", trace.WithAttributes(
+		attribute.String("url.full", endpoint),
+		attribute.String("error.message", err.Error()),
+	))
+}
+
+// endSendSpan records the endpoint and number of POSTs, the HTTP status
+// and HEC status code of the last response, or the error, and ends the
+// span
+func endSendSpan(span trace.Span, endpoint string, attempts int, err error) {
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("url.full", endpoint),
+		attribute.Int("hec.attempts", attempts),
+	)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", http.StatusOK))
+		return
+	}
+
+	var resp *splunk.EventCollectorResponse
+	if errors.As(err, &resp) {
+		span.SetAttributes(attribute.Int("hec.code", int(resp.Code)))
+		if status, codeErr := resp.Code.HTTPCode(); codeErr == nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+		}
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,73 @@
+// Package invocation holds the steps every entry point runs for an
+// invocation once its provider has parsed the events, so that each main
+// only wires its provider and runtime.
+package invocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/processor"
+	"github.com/mosajjal/whatthehec/pkg/tracing"
+)
+
+// traceFlushTimeout bounds exporting the spans of one invocation
+const traceFlushTimeout = 2 * time.Second
+
+// Process runs the processing pipeline and logs how many events it dropped
+func Process(ctx context.Context, pipeline processor.Processor, events []*models.Event) ([]*models.Event, error) {
+	received := len(events)
+	events, err := pipeline.Process(ctx, events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process events: %w", err)
+	}
+	if dropped := received - len(events); dropped > 0 {
+		slog.DebugContext(ctx, "Dropped events", "dropped", dropped, "received", received)
+	}
+	return events, nil
+}
+
+// LogDropped logs the events a send neither delivered nor stored, with the
+// HEC responses that explain why
+func LogDropped(ctx context.Context, result *hec.Result, err error) {
+	for _, resp := range result.Responses {
+		if resp.StatusCode != http.StatusOK {
+			slog.WarnContext(ctx, "HEC rejected events", "destination", resp.Destination, "endpoint", resp.Endpoint,
+				"status", resp.StatusCode, "code", resp.Code, "text", resp.Text, "invalid_event", resp.InvalidEvent)
+		}
+	}
+	slog.ErrorContext(ctx, "Failed to send events to HEC", "dropped", len(result.Dropped()),
+		"delivered", result.Count(hec.Delivered), "stored", result.Count(hec.Stored), "error", err)
+}
+
+// Flush writes what sender buffered for storage, and marks the events of
+// result that failure storage did not write as dropped
+func Flush(ctx context.Context, sender hec.Sender, result *hec.Result) {
+	err := sender.Flush(ctx)
+	if err == nil {
+		return
+	}
+	slog.ErrorContext(ctx, "Failed to flush storage", "error", err)
+	if errors.Is(err, hec.ErrNotStored) {
+		result.Unstore(err)
+	}
+}
+
+// End ends the invocation span and exports the spans recorded during it.
+// A nil tracer is ignored.
+func End(span trace.Span, tracer *tracing.Provider) {
+	span.End()
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := tracer.Flush(ctx); err != nil {
+		slog.Warn("Failed to export traces", "error", err)
+	}
+}
@@ -0,0 +1,39 @@
+package invocation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/processor"
+)
+
+// processorFunc adapts a function to processor.Processor
+type processorFunc func(ctx context.Context, events []*models.Event) ([]*models.Event, error)
+
+func (f processorFunc) Process(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	return f(ctx, events)
+}
+
+func TestProcess(t *testing.T) {
+	dropFirst := processorFunc(func(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+		return events[1:], nil
+	})
+	events, err := Process(context.Background(), processor.Chain{dropFirst}, []*models.Event{{Event: "a"}, {Event: "b"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 1 || events[0].Event != "b" {
+		t.Errorf("Expected only the second event, got %v", events)
+	}
+
+	fail := processorFunc(func(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+		return nil, errors.New("bad rule")
+	})
+	_, err = Process(context.Background(), fail, []*models.Event{{Event: "a"}})
+	if err == nil || !strings.Contains(err.Error(), "failed to process events") {
+		t.Errorf("Expected the processing error, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Package logging configures the structured JSON logger used by the
+// forwarder packages through log/slog. Records carry the invocation and
+// trace IDs of their context and a logger attribute that marks them as the
+// forwarder's own, and event payloads are never written.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// Name is the value of the logger attribute of every record, by which the
+// forwarder's own log lines can be recognized if they are forwarded back
+const Name = "whatthehec"
+
+// Record attribute keys
+const (
+	LoggerKey     = "logger"
+	InvocationKey = "invocation_id"
+	TraceKey      = "trace_id"
+)
+
+// omitted replaces attribute values that may hold event payloads
+const omitted = "[omitted]"
+
+// payloadKeys are attribute keys whose values are never logged
+var payloadKeys = map[string]bool{
+	"event":   true,
+	"events":  true,
+	"payload": true,
+	"data":    true,
+	"body":    true,
+	"raw":     true,
+}
+
+// ParseLevel parses debug, info, warn or error, case-insensitively
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// New creates a JSON logger writing records at level and above to w
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: omitPayloads,
+	})
+	return slog.New(contextHandler{handler}).With(LoggerKey, Name)
+}
+
+// Setup makes a JSON logger at the named level, writing to stderr, the
+// default for slog and the log package
+func Setup(level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(New(os.Stderr, l))
+	return nil
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type invocationKey struct{}
+
+// WithInvocation returns ctx with an invocation ID, such as the Lambda
+// request ID, that is added to every record logged with ctx
+func WithInvocation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, invocationKey{}, id)
+}
+
+// contextHandler adds the invocation and trace IDs of the record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(invocationKey{}).(string); ok && id != "" {
+		r.AddAttrs(slog.String(InvocationKey, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(TraceKey, sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// omitPayloads replaces attributes that may hold event payloads, so a
+// forwarder subscribed to its own log group cannot amplify its input
+func omitPayloads(groups []string, a slog.Attr) slog.Attr {
+	if payloadKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, omitted)
+	}
+	if a.Value.Kind() == slog.KindAny {
+		switch a.Value.Any().(type) {
+		case *models.Event, []*models.Event, *models.CloudEvent, []*models.CloudEvent, []byte:
+			return slog.String(a.Key, omitted)
+		}
+	}
+	return a
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected JSON record, got %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNew_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelWarn)
+	logger.Info("dropped")
+	logger.Warn("kept", "count", 3)
+
+	records := decode(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	if records[0]["msg"] != "kept" || records[0]["level"] != "WARN" {
+		t.Errorf("Unexpected record %v", records[0])
+	}
+	if records[0][LoggerKey] != Name {
+		t.Errorf("Expected logger %q, got %v", Name, records[0][LoggerKey])
+	}
+}
+
+func TestNew_ContextIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	ctx = WithInvocation(ctx, "8476a536-e9f4-11e8-9739-2dfe598c3fcd")
+
+	logger.With("endpoint", "https://splunk:8088").InfoContext(ctx, "sent")
+	logger.Info("no context")
+
+	records := decode(t, &buf)
+	if records[0][InvocationKey] != "8476a536-e9f4-11e8-9739-2dfe598c3fcd" {
+		t.Errorf("Expected invocation ID, got %v", records[0][InvocationKey])
+	}
+	if records[0][TraceKey] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected trace ID, got %v", records[0][TraceKey])
+	}
+	if records[0]["endpoint"] != "https://splunk:8088" {
+		t.Errorf("Expected attributes to be kept, got %v", records[0])
+	}
+	if _, ok := records[1][InvocationKey]; ok {
+		t.Errorf("Expected no invocation ID without context, got %v", records[1])
+	}
+}
+
+func TestNew_OmitsPayloads(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+	secret := "password=hunter2"
+	logger.Info("failed",
+		"event", secret,
+		"payload", map[string]string{"message": secret},
+		"batch", []*models.Event{{Event: secret}},
+		"raw_bytes", []byte(secret),
+		"count", 1)
+
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("Expected payloads to be omitted, got %s", buf.String())
+	}
+	records := decode(t, &buf)
+	if records[0]["count"] != float64(1) {
+		t.Errorf("Expected other attributes to be kept, got %v", records[0])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"debug", "INFO", "warn", "error"} {
+		if _, err := ParseLevel(s); err != nil {
+			t.Errorf("Expected %q to parse, got %v", s, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EMF writes the registry as CloudWatch Embedded Metric Format log lines,
+// from which CloudWatch creates metrics without an exporter. Counters and
+// histograms are written as the change since the previous Write, so each
+// line describes one Lambda invocation; gauges are written as they are.
+type EMF struct {
+	registry   *Registry
+	namespace  string
+	dimensions map[string]string
+	w          io.Writer
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// NewEMF creates an EMF writer. The dimensions, such as the function name,
+// are added to every metric.
+func NewEMF(registry *Registry, namespace string, dimensions map[string]string, w io.Writer) *EMF {
+	return &EMF{
+		registry:   registry,
+		namespace:  namespace,
+		dimensions: dimensions,
+		w:          w,
+		last:       make(map[string]float64),
+	}
+}
+
+// emfLine holds the metrics that share one set of dimension values
+type emfLine struct {
+	dimensions map[string]string
+	names      []string
+	units      []string
+	values     []float64
+}
+
+// Write writes one line per set of label values with changed metrics
+func (e *EMF) Write() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lines := make(map[string]*emfLine)
+	var order []string
+	add := func(f *family, s *series, value float64) {
+		dims := make(map[string]string, len(e.dimensions)+len(f.labels))
+		for k, v := range e.dimensions {
+			dims[k] = v
+		}
+		pairs := make([]string, len(f.labels))
+		for i, label := range f.labels {
+			dims[label] = s.labels[i]
+			pairs[i] = label + "=" + s.labels[i]
+		}
+		sort.Strings(pairs)
+		key := strings.Join(pairs, "\xff")
+		line, ok := lines[key]
+		if !ok {
+			line = &emfLine{dimensions: dims}
+			lines[key] = line
+			order = append(order, key)
+		}
+		line.names = append(line.names, f.name)
+		line.units = append(line.units, emfUnit(f))
+		line.values = append(line.values, value)
+	}
+
+	e.registry.mu.Lock()
+	families := append([]*family(nil), e.registry.families...)
+	e.registry.mu.Unlock()
+
+	for _, f := range families {
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			id := f.name + "\xfe" + key
+			switch f.kind {
+			case TypeGauge:
+				add(f, s, s.value)
+			case TypeCounter:
+				if delta := s.value - e.last[id]; delta != 0 {
+					add(f, s, delta)
+				}
+				e.last[id] = s.value
+			case TypeHistogram:
+				// The mean of this invocation's observations
+				count := float64(s.count) - e.last[id+"\xfecount"]
+				sum := s.sum - e.last[id+"\xfesum"]
+				if count > 0 {
+					add(f, s, sum/count)
+				}
+				e.last[id+"\xfecount"] = float64(s.count)
+				e.last[id+"\xfesum"] = s.sum
+			}
+		}
+		f.mu.Unlock()
+	}
+
+	timestamp := time.Now().UnixMilli()
+	for _, key := range order {
+		data, err := json.Marshal(e.document(lines[key], timestamp))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(e.w, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// document builds the EMF JSON object for one line
+func (e *EMF) document(line *emfLine, timestamp int64) map[string]interface{} {
+	names := make([]string, 0, len(line.dimensions))
+	for name := range line.dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]map[string]string, len(line.names))
+	doc := make(map[string]interface{}, len(line.dimensions)+len(line.names)+1)
+	for i, name := range line.names {
+		metrics[i] = map[string]string{"Name": name, "Unit": line.units[i]}
+		doc[name] = line.values[i]
+	}
+	for name, value := range line.dimensions {
+		doc[name] = value
+	}
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  e.namespace,
+			"Dimensions": [][]string{names},
+			"Metrics":    metrics,
+		}},
+	}
+	return doc
+}
+
+// emfUnit returns the CloudWatch unit for a metric, based on its name
+func emfUnit(f *family) string {
+	switch {
+	case f.kind == TypeGauge:
+		return "None"
+	case strings.HasSuffix(f.name, "_seconds"):
+		return "Seconds"
+	case strings.HasSuffix(f.name, "_bytes_total"):
+		return "Bytes"
+	default:
+		return "Count"
+	}
+}
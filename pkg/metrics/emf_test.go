@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEMF_Write(t *testing.T) {
+	r := NewRegistry()
+	events := r.Counter("test_events_total", "Events", "endpoint")
+	healthy := r.Gauge("test_healthy", "Health", "endpoint")
+	latency := r.Histogram("test_latency_seconds", "Latency", DefaultBuckets, "endpoint")
+
+	var out strings.Builder
+	emf := NewEMF(r, "whatthehec", map[string]string{"FunctionName": "forwarder"}, &out)
+
+	events.Add(5, "a")
+	healthy.Set(1, "a")
+	latency.Observe(0.2, "a")
+	latency.Observe(0.4, "a")
+	if err := emf.Write(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line for one set of labels, got %d: %s", len(lines), out.String())
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &doc); err != nil {
+		t.Fatalf("Expected JSON, got %s", lines[0])
+	}
+	if doc["test_events_total"] != float64(5) || doc["test_healthy"] != float64(1) || doc["endpoint"] != "a" || doc["FunctionName"] != "forwarder" {
+		t.Errorf("Unexpected values %v", doc)
+	}
+	if latency := doc["test_latency_seconds"].(float64); latency < 0.29 || latency > 0.31 {
+		t.Errorf("Expected mean latency 0.3, got %v", latency)
+	}
+
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	if directive["Namespace"] != "whatthehec" {
+		t.Errorf("Expected namespace, got %v", directive["Namespace"])
+	}
+	dims := directive["Dimensions"].([]interface{})[0].([]interface{})
+	if len(dims) != 2 || dims[0] != "FunctionName" || dims[1] != "endpoint" {
+		t.Errorf("Expected FunctionName and endpoint dimensions, got %v", dims)
+	}
+	for _, m := range directive["Metrics"].([]interface{}) {
+		metric := m.(map[string]interface{})
+		if metric["Name"] == "test_latency_seconds" && metric["Unit"] != "Seconds" {
+			t.Errorf("Expected Seconds unit, got %v", metric["Unit"])
+		}
+	}
+
+	// The next invocation only reports what changed
+	out.Reset()
+	events.Add(2, "a")
+	emf.Write()
+	doc = nil
+	json.Unmarshal([]byte(strings.TrimSpace(out.String())), &doc)
+	if doc["test_events_total"] != float64(2) {
+		t.Errorf("Expected counter delta 2, got %v", doc["test_events_total"])
+	}
+	if _, ok := doc["test_latency_seconds"]; ok {
+		t.Error("Expected histogram without new observations to be omitted")
+	}
+}
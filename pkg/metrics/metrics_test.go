@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	events := r.Counter("test_events_total", "Events sent", "endpoint", "result")
+	healthy := r.Gauge("test_healthy", "Endpoint health", "endpoint")
+	latency := r.Histogram("test_latency_seconds", "Latency", []float64{0.1, 1}, "endpoint")
+	r.Counter("test_unused_total", "Never incremented")
+
+	events.Add(3, "https://splunk:8088", "sent")
+	events.Inc("https://splunk:8088", "sent")
+	events.Inc(`a"b`, "failed")
+	healthy.Set(1, "https://splunk:8088")
+	latency.Observe(0.05, "a")
+	latency.Observe(0.5, "a")
+	latency.Observe(5, "a")
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	text := b.String()
+
+	for _, want := range []string{
+		"# HELP test_events_total Events sent\n# TYPE test_events_total counter\n",
+		`test_events_total{endpoint="https://splunk:8088",result="sent"} 4`,
+		`test_events_total{endpoint="a\"b",result="failed"} 1`,
+		`test_healthy{endpoint="https://splunk:8088"} 1`,
+		`test_latency_seconds_bucket{endpoint="a",le="0.1"} 1`,
+		`test_latency_seconds_bucket{endpoint="a",le="1"} 2`,
+		`test_latency_seconds_bucket{endpoint="a",le="+Inf"} 3`,
+		`test_latency_seconds_sum{endpoint="a"} 5.55`,
+		`test_latency_seconds_count{endpoint="a"} 3`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "test_unused_total") {
+		t.Error("Expected metrics without samples to be omitted")
+	}
+
+	if v := r.Value("test_events_total", "https://splunk:8088", "sent"); v != 4 {
+		t.Errorf("Expected value 4, got %v", v)
+	}
+	if v := r.Value("test_latency_seconds", "a"); v != 3 {
+		t.Errorf("Expected histogram count 3, got %v", v)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	a := r.Counter("test_total", "Test", "label")
+	b := r.Counter("test_total", "Test", "label")
+	a.Inc("x")
+	b.Inc("x")
+	if v := r.Value("test_total", "x"); v != 2 {
+		t.Errorf("Expected registering twice to share the counter, got %v", v)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for conflicting registration")
+		}
+	}()
+	r.Gauge("test_total", "Test", "label")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Test").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "test_total 1") {
+		t.Errorf("Expected counter in response, got %s", body)
+	}
+}
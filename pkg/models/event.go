@@ -10,6 +10,24 @@ type Event struct {
 	SourceType string
 	Index      string
 	Event      interface{}
+	Metadata   map[string]string // Not sent to HEC; used for routing and storage keys
+}
+
+// Field returns the value of an event field by name: index, sourcetype,
+// source, host, or any Metadata key
+func (e *Event) Field(name string) string {
+	switch name {
+	case "index":
+		return e.Index
+	case "sourcetype":
+		return e.SourceType
+	case "source":
+		return e.Source
+	case "host":
+		return e.Host
+	default:
+		return e.Metadata[name]
+	}
 }
 
 // CloudEvent represents a cloud provider-agnostic log event
@@ -21,4 +39,5 @@ type CloudEvent struct {
 	Message      string      // Log message
 	Metadata     map[string]string // Additional metadata
 	RawData      []byte      // Original raw data
+	Payload      interface{} // Structured payload sent instead of RawData, if set
 }
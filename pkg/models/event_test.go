@@ -47,3 +47,27 @@ func TestCloudEvent(t *testing.T) {
 		t.Errorf("Expected raw data to be 'raw data', got '%s'", string(cloudEvent.RawData))
 	}
 }
+
+func TestEvent_Field(t *testing.T) {
+	event := &Event{
+		Index:      "main",
+		SourceType: "aws:cloudwatch",
+		Source:     "aws-lambda",
+		Host:       "lambda",
+		Metadata:   map[string]string{"loggroup": "/aws/lambda/app"},
+	}
+
+	tests := map[string]string{
+		"index":      "main",
+		"sourcetype": "aws:cloudwatch",
+		"source":     "aws-lambda",
+		"host":       "lambda",
+		"loggroup":   "/aws/lambda/app",
+		"missing":    "",
+	}
+	for name, expected := range tests {
+		if got := event.Field(name); got != expected {
+			t.Errorf("Field(%q): expected '%s', got '%s'", name, expected, got)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFormat turns one log line of a well-known format into named fields
+type LogFormat struct {
+	Name       string
+	SourceType string
+	// Parse returns the fields of a line, or false if it is not in this
+	// format
+	Parse func(line string) (map[string]interface{}, bool)
+}
+
+// Built-in log format names
+const (
+	FormatVPCFlow         = "vpcflow"
+	FormatALB             = "alb"
+	FormatNLB             = "nlb"
+	FormatCloudFront      = "cloudfront"
+	FormatRoute53Resolver = "route53resolver"
+	FormatAuto            = "auto"
+)
+
+// LogFormats are the built-in formats, in the order auto-detection tries
+// them
+var LogFormats = []LogFormat{
+	{Name: FormatRoute53Resolver, SourceType: "aws:route53:resolver", Parse: parseRoute53Resolver},
+	{Name: FormatVPCFlow, SourceType: "aws:cloudwatchlogs:vpcflow", Parse: parseVPCFlow},
+	{Name: FormatALB, SourceType: "aws:elb:accesslogs", Parse: parseALB},
+	{Name: FormatNLB, SourceType: "aws:elb:accesslogs", Parse: parseNLB},
+	{Name: FormatCloudFront, SourceType: "aws:cloudfront:accesslogs", Parse: parseCloudFront},
+}
+
+// LookupLogFormat returns a built-in format by name
+func LookupLogFormat(name string) (LogFormat, bool) {
+	for _, f := range LogFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return LogFormat{}, false
+}
+
+// field kinds
+const (
+	kindString = iota
+	kindInt
+	kindFloat
+	kindHostPort // split into <name>_ip and <name>_port
+)
+
+type fieldSpec struct {
+	name string
+	kind int
+}
+
+// setFields assigns values to named fields, skipping "-" which AWS uses for
+// missing values. It returns false if a numeric field does not parse.
+func setFields(fields map[string]interface{}, specs []fieldSpec, values []string) bool {
+	for i, spec := range specs {
+		if i >= len(values) {
+			break
+		}
+		value := values[i]
+		if value == "-" || value == "" {
+			continue
+		}
+		switch spec.kind {
+		case kindInt:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return false
+			}
+			fields[spec.name] = n
+		case kindFloat:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return false
+			}
+			fields[spec.name] = f
+		case kindHostPort:
+			host, port, err := net.SplitHostPort(value)
+			if err != nil {
+				return false
+			}
+			fields[spec.name+"_ip"] = host
+			if n, err := strconv.Atoi(port); err == nil {
+				fields[spec.name+"_port"] = n
+			}
+		default:
+			fields[spec.name] = value
+		}
+	}
+	return true
+}
+
+// VPC flow log fields whose values are numbers
+var vpcFlowNumeric = map[string]bool{
+	"version": true, "srcport": true, "dstport": true, "protocol": true,
+	"packets": true, "bytes": true, "start": true, "end": true,
+	"tcp_flags": true, "traffic_path": true,
+}
+
+// vpcFlowDefault is the default (version 2) VPC flow log format
+var vpcFlowDefault = vpcFlowSpecs([]string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+})
+
+// vpcFlowSpecs builds field specs from VPC flow log field names, given as
+// in the AWS format string (${vpc-id} or vpc-id)
+func vpcFlowSpecs(names []string) []fieldSpec {
+	specs := make([]fieldSpec, len(names))
+	for i, name := range names {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "${"), "}")
+		name = strings.ReplaceAll(name, "-", "_")
+		specs[i] = fieldSpec{name: name}
+		if vpcFlowNumeric[name] {
+			specs[i].kind = kindInt
+		}
+	}
+	return specs
+}
+
+func parseVPCFlow(line string) (map[string]interface{}, bool) {
+	values := strings.Fields(line)
+	if len(values) != len(vpcFlowDefault) {
+		return nil, false
+	}
+	switch values[12] {
+	case "ACCEPT", "REJECT", "-":
+	default:
+		return nil, false
+	}
+	switch values[13] {
+	case "OK", "NODATA", "SKIPDATA":
+	default:
+		return nil, false
+	}
+	fields := make(map[string]interface{}, len(values))
+	if !setFields(fields, vpcFlowDefault, values) {
+		return nil, false
+	}
+	return fields, true
+}
+
+// VPCFlowFormat returns the VPC flow log format for a custom field list
+func VPCFlowFormat(names []string) LogFormat {
+	format, _ := LookupLogFormat(FormatVPCFlow)
+	specs := vpcFlowSpecs(names)
+	format.Parse = func(line string) (map[string]interface{}, bool) {
+		values := strings.Fields(line)
+		if len(values) != len(specs) {
+			return nil, false
+		}
+		fields := make(map[string]interface{}, len(values))
+		if !setFields(fields, specs, values) {
+			return nil, false
+		}
+		return fields, true
+	}
+	return format
+}
+
+var albSpecs = []fieldSpec{
+	{"type", kindString}, {"time", kindString}, {"elb", kindString},
+	{"client", kindHostPort}, {"target", kindHostPort},
+	{"request_processing_time", kindFloat}, {"target_processing_time", kindFloat}, {"response_processing_time", kindFloat},
+	{"elb_status_code", kindInt}, {"target_status_code", kindInt},
+	{"received_bytes", kindInt}, {"sent_bytes", kindInt},
+	{"request", kindString}, {"user_agent", kindString},
+	{"ssl_cipher", kindString}, {"ssl_protocol", kindString}, {"target_group_arn", kindString},
+	{"trace_id", kindString}, {"domain_name", kindString}, {"chosen_cert_arn", kindString},
+	{"matched_rule_priority", kindString}, {"request_creation_time", kindString},
+	{"actions_executed", kindString}, {"redirect_url", kindString}, {"error_reason", kindString},
+	{"target_port_list", kindString}, {"target_status_code_list", kindString},
+	{"classification", kindString}, {"classification_reason", kindString}, {"conn_trace_id", kindString},
+}
+
+func parseALB(line string) (map[string]interface{}, bool) {
+	values, ok := splitQuoted(line)
+	if !ok || len(values) < 13 {
+		return nil, false
+	}
+	switch values[0] {
+	case "http", "https", "h2", "grpcs", "ws", "wss":
+	default:
+		return nil, false
+	}
+	if !isTimestamp(values[1]) {
+		return nil, false
+	}
+	fields := make(map[string]interface{}, len(albSpecs)+4)
+	if !setFields(fields, albSpecs, values) {
+		return nil, false
+	}
+	// "GET https://example.com:443/path HTTP/1.1"
+	if parts := strings.SplitN(values[12], " ", 3); len(parts) == 3 {
+		fields["request_method"] = parts[0]
+		fields["request_url"] = parts[1]
+		fields["request_protocol"] = parts[2]
+	}
+	return fields, true
+}
+
+var nlbSpecs = []fieldSpec{
+	{"type", kindString}, {"version", kindString}, {"time", kindString}, {"elb", kindString},
+	{"listener", kindString}, {"client", kindHostPort}, {"destination", kindHostPort},
+	{"connection_time", kindInt}, {"tls_handshake_time", kindInt},
+	{"received_bytes", kindInt}, {"sent_bytes", kindInt},
+	{"incoming_tls_alert", kindString}, {"chosen_cert_arn", kindString}, {"chosen_cert_serial", kindString},
+	{"tls_cipher", kindString}, {"tls_protocol_version", kindString}, {"tls_named_group", kindString},
+	{"domain_name", kindString}, {"alpn_fe_protocol", kindString}, {"alpn_be_protocol", kindString},
+	{"alpn_client_preference_list", kindString}, {"tls_connection_creation_time", kindString},
+}
+
+func parseNLB(line string) (map[string]interface{}, bool) {
+	values := strings.Fields(line)
+	if len(values) < 11 || values[0] != "tls" {
+		return nil, false
+	}
+	if !isTimestamp(values[2]) {
+		return nil, false
+	}
+	fields := make(map[string]interface{}, len(nlbSpecs)+2)
+	if !setFields(fields, nlbSpecs, values) {
+		return nil, false
+	}
+	return fields, true
+}
+
+var cloudFrontSpecs = cloudFrontFieldSpecs(
+	"date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer) " +
+		"cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id x-host-header " +
+		"cs-protocol cs-bytes time-taken x-forwarded-for ssl-protocol ssl-cipher x-edge-response-result-type " +
+		"cs-protocol-version fle-status fle-encrypted-fields c-port time-to-first-byte " +
+		"x-edge-detailed-result-type sc-content-type sc-content-len sc-range-start sc-range-end")
+
+var cloudFrontNumeric = map[string]int{
+	"sc_bytes": kindInt, "sc_status": kindInt, "cs_bytes": kindInt, "time_taken": kindFloat,
+	"c_port": kindInt, "time_to_first_byte": kindFloat, "sc_content_len": kindInt,
+	"sc_range_start": kindInt, "sc_range_end": kindInt,
+}
+
+// cloudFrontFieldSpecs converts CloudFront field names (cs(User-Agent)) to
+// field specs (cs_user_agent)
+func cloudFrontFieldSpecs(names string) []fieldSpec {
+	replacer := strings.NewReplacer("(", "_", ")", "", "-", "_")
+	var specs []fieldSpec
+	for _, name := range strings.Fields(names) {
+		name = strings.ToLower(replacer.Replace(name))
+		specs = append(specs, fieldSpec{name: name, kind: cloudFrontNumeric[name]})
+	}
+	return specs
+}
+
+var cloudFrontDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+func parseCloudFront(line string) (map[string]interface{}, bool) {
+	values := strings.Split(line, "\t")
+	if len(values) < 19 || !cloudFrontDate.MatchString(values[0]) {
+		return nil, false
+	}
+	fields := make(map[string]interface{}, len(values))
+	if !setFields(fields, cloudFrontSpecs, values) {
+		return nil, false
+	}
+	return fields, true
+}
+
+func parseRoute53Resolver(line string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return nil, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil, false
+	}
+	for _, key := range []string{"query_name", "query_type", "rcode"} {
+		if _, ok := fields[key]; !ok {
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+// isTimestamp reports whether s starts with an ISO 8601 date and time;
+// NLB logs omit the zone
+func isTimestamp(s string) bool {
+	if len(s) < 19 {
+		return false
+	}
+	_, err := time.Parse("2006-01-02T15:04:05", s[:19])
+	return err == nil
+}
+
+// splitQuoted splits a line on spaces, keeping double-quoted values (which
+// may contain spaces and \" escapes) together and unquoted
+func splitQuoted(line string) ([]string, bool) {
+	var values []string
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == ' ':
+			i++
+		case line[i] == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				b.WriteByte(line[j])
+			}
+			if j >= len(line) {
+				return nil, false
+			}
+			values = append(values, b.String())
+			i = j + 1
+		default:
+			j := strings.IndexByte(line[i:], ' ')
+			if j < 0 {
+				j = len(line) - i
+			}
+			values = append(values, line[i:i+j])
+			i += j
+		}
+	}
+	return values, true
+}
+
+// ValidateLogFormat checks a format name and its custom fields
+func ValidateLogFormat(name string, fields []string) error {
+	if name != FormatAuto {
+		if _, ok := LookupLogFormat(name); !ok {
+			return fmt.Errorf("unknown log format %q", name)
+		}
+	}
+	if len(fields) > 0 && name != FormatVPCFlow {
+		return fmt.Errorf("custom fields are only supported for the %s format", FormatVPCFlow)
+	}
+	return nil
+}
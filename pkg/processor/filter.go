@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// routeDropped counts the events dropped by each filter route
+var routeDropped = metrics.Default.Counter("whatthehec_filter_events_dropped_total",
+	"Events dropped by a filter route, by route and reason (filtered or sampled)", "route", "reason")
+
+// FilterRoute selects events by their fields and decides which of them to
+// keep. Payload rules apply to the event payload as a string, field rules
+// to JSON payloads by JSONPath.
+type FilterRoute struct {
+	Name          string
+	Match         map[string]string // event field (index, sourcetype, loggroup, ...) to regex; empty matches all
+	Include       []string          // keep only payloads matching one of these regexes
+	Exclude       []string          // drop payloads matching any of these regexes
+	IncludeFields map[string]string // keep only JSON payloads where every path equals its value
+	ExcludeFields map[string]string // drop JSON payloads where any path equals its value
+	SampleRate    *float64          // fraction of the remaining events to keep; nil keeps all
+	SampleKey     string            // JSONPath ($.requestId) or event field (requestid) to sample by instead of the payload
+}
+
+// FilterStats counts the events dropped by one route
+type FilterStats struct {
+	Route    string
+	Filtered uint64 // dropped by include/exclude rules
+	Sampled  uint64 // dropped by sampling
+}
+
+// Filter drops events using the first route that matches each event.
+// Events that match no route are kept.
+type Filter struct {
+	routes []*filterRoute
+
+	mu    sync.Mutex
+	stats []FilterStats
+}
+
+type filterRoute struct {
+	match         map[string]*regexp.Regexp
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	includeFields map[string]string
+	excludeFields map[string]string
+	sampleRate    float64
+	sampleKey     string
+	seed          string
+}
+
+// NewFilter compiles the routes
+func NewFilter(routes []FilterRoute) (*Filter, error) {
+	f := &Filter{stats: make([]FilterStats, len(routes))}
+	for i, r := range routes {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("route%d", i)
+		}
+		route, err := compileRoute(r, name)
+		if err != nil {
+			return nil, fmt.Errorf("filter route %s: %w", name, err)
+		}
+		f.routes = append(f.routes, route)
+		f.stats[i].Route = name
+	}
+	return f, nil
+}
+
+func compileRoute(r FilterRoute, name string) (*filterRoute, error) {
+	sampleRate := 1.0
+	if r.SampleRate != nil {
+		sampleRate = *r.SampleRate
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1")
+	}
+
+	route := &filterRoute{
+		match:         make(map[string]*regexp.Regexp),
+		includeFields: r.IncludeFields,
+		excludeFields: r.ExcludeFields,
+		sampleRate:    sampleRate,
+		sampleKey:     r.SampleKey,
+		seed:          name,
+	}
+	for field, pattern := range r.Match {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("match %s: %w", field, err)
+		}
+		route.match[field] = re
+	}
+	for _, pattern := range r.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+		route.include = append(route.include, re)
+	}
+	for _, pattern := range r.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("exclude: %w", err)
+		}
+		route.exclude = append(route.exclude, re)
+	}
+	if strings.HasPrefix(r.SampleKey, "$") {
+		if err := ValidatePath(r.SampleKey); err != nil {
+			return nil, fmt.Errorf("sample key: %w", err)
+		}
+	}
+	for _, fields := range []map[string]string{r.IncludeFields, r.ExcludeFields} {
+		for path := range fields {
+			if err := ValidatePath(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return route, nil
+}
+
+// Process drops filtered and sampled-out events
+func (f *Filter) Process(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	kept := make([]*models.Event, 0, len(events))
+	filtered := make([]uint64, len(f.routes))
+	sampled := make([]uint64, len(f.routes))
+
+	for _, event := range events {
+		i := f.route(event)
+		if i < 0 {
+			kept = append(kept, event)
+			continue
+		}
+		route := f.routes[i]
+		payload := Payload(event)
+		switch {
+		case !route.keep(event, payload):
+			filtered[i]++
+		case !route.sample(route.key(event, payload)):
+			sampled[i]++
+		default:
+			kept = append(kept, event)
+		}
+	}
+
+	f.mu.Lock()
+	for i := range f.stats {
+		f.stats[i].Filtered += filtered[i]
+		f.stats[i].Sampled += sampled[i]
+		if filtered[i] > 0 {
+			routeDropped.Add(float64(filtered[i]), f.stats[i].Route, "filtered")
+		}
+		if sampled[i] > 0 {
+			routeDropped.Add(float64(sampled[i]), f.stats[i].Route, "sampled")
+		}
+	}
+	f.mu.Unlock()
+
+	return kept, nil
+}
+
+// Stats returns the number of events dropped by each route so far
+func (f *Filter) Stats() []FilterStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FilterStats(nil), f.stats...)
+}
+
+// route returns the index of the first route matching the event, or -1
+func (f *Filter) route(event *models.Event) int {
+	for i, route := range f.routes {
+		matched := true
+		for field, re := range route.match {
+			if !re.MatchString(event.Field(field)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+// keep applies the include and exclude rules
+func (r *filterRoute) keep(event *models.Event, payload string) bool {
+	for _, re := range r.exclude {
+		if re.MatchString(payload) {
+			return false
+		}
+	}
+	if len(r.include) > 0 {
+		included := false
+		for _, re := range r.include {
+			if re.MatchString(payload) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	if len(r.includeFields) == 0 && len(r.excludeFields) == 0 {
+		return true
+	}
+	doc, isJSON := JSONPayload(event)
+	for path, value := range r.excludeFields {
+		if isJSON && fieldEquals(doc, path, value) {
+			return false
+		}
+	}
+	for path, value := range r.includeFields {
+		if !isJSON || !fieldEquals(doc, path, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the value an event is sampled by: the SampleKey field if
+// the event has it, or else the payload
+func (r *filterRoute) key(event *models.Event, payload string) string {
+	switch {
+	case r.sampleKey == "" || r.sampleRate == 1:
+	case strings.HasPrefix(r.sampleKey, "$"):
+		if doc, ok := JSONPayload(event); ok {
+			if v, ok := Lookup(doc, r.sampleKey); ok {
+				return fmt.Sprint(v)
+			}
+		}
+	default:
+		if v := event.Field(r.sampleKey); v != "" {
+			return v
+		}
+	}
+	return payload
+}
+
+// sample keeps a fraction of events by hashing the route and the event's
+// key, so an event gets the same decision on every instance, retry and
+// replay. Events with the same key are all kept or all dropped.
+func (r *filterRoute) sample(key string) bool {
+	if r.sampleRate == 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(r.seed))
+	h.Write([]byte(key))
+	return float64(mix(h.Sum64())) < r.sampleRate*math.MaxUint64
+}
+
+// mix spreads FNV output over all 64 bits (splitmix64 finalizer); the high
+// bits of FNV alone are poorly distributed for short inputs
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func fieldEquals(doc interface{}, path, value string) bool {
+	v, ok := Lookup(doc, path)
+	if !ok {
+		return false
+	}
+	switch v := v.(type) {
+	case string:
+		return v == value
+	case nil:
+		return value == "null"
+	default:
+		return fmt.Sprint(v) == value
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the value at a JSONPath such as $.detail.level or
+// $.records[0].name. Only child and array index selectors are supported.
+func Lookup(v interface{}, path string) (interface{}, bool) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, false
+	}
+	for _, seg := range segments {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if seg.isIndex {
+				return nil, false
+			}
+			var ok bool
+			if v, ok = node[seg.key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			if !seg.isIndex || seg.index >= len(node) {
+				return nil, false
+			}
+			v = node[seg.index]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath splits $.a.b[0] into segments. The leading $ is optional.
+func parsePath(path string) ([]pathSegment, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+
+	var segments []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		key, rest, bracket := strings.Cut(part, "[")
+		if key != "" {
+			segments = append(segments, pathSegment{key: key})
+		}
+		if bracket {
+			rest = "[" + rest
+		}
+		for rest != "" {
+			idx, after, ok := strings.Cut(strings.TrimPrefix(rest, "["), "]")
+			if !ok || !strings.HasPrefix(rest, "[") {
+				return nil, fmt.Errorf("invalid JSONPath %q", path)
+			}
+			n, err := strconv.Atoi(idx)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid index %q in JSONPath %q", idx, path)
+			}
+			segments = append(segments, pathSegment{index: n, isIndex: true})
+			rest = after
+		}
+	}
+	return segments, nil
+}
+
+// ValidatePath reports whether path is a supported JSONPath
+func ValidatePath(path string) error {
+	_, err := parsePath(path)
+	return err
+}
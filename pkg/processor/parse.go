@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// ParseRoute selects events by their fields and parses their message with
+// a log format
+type ParseRoute struct {
+	Name       string
+	Match      map[string]string // event field (loggroup, sourcetype, ...) to regex; empty matches all
+	Format     string            // a LogFormats name, or FormatAuto to detect it
+	SourceType string            // overrides the format's sourcetype
+	Fields     []string          // custom VPC flow log format fields
+}
+
+// Parser turns log lines of well-known formats into JSON events with named
+// fields and sets their sourcetype. It uses the first route that matches
+// each event; events that match no route, or whose message is not in the
+// route's format, are left unchanged.
+type Parser struct {
+	routes []*parseRoute
+}
+
+type parseRoute struct {
+	match      map[string]*regexp.Regexp
+	formats    []LogFormat
+	sourceType string
+}
+
+// NewParser compiles the routes
+func NewParser(routes []ParseRoute) (*Parser, error) {
+	p := &Parser{}
+	for i, r := range routes {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("route%d", i)
+		}
+		if err := ValidateLogFormat(r.Format, r.Fields); err != nil {
+			return nil, fmt.Errorf("parse route %s: %w", name, err)
+		}
+
+		route := &parseRoute{
+			match:      make(map[string]*regexp.Regexp),
+			sourceType: r.SourceType,
+		}
+		for field, pattern := range r.Match {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("parse route %s: match %s: %w", name, field, err)
+			}
+			route.match[field] = re
+		}
+		switch {
+		case r.Format == FormatAuto:
+			route.formats = LogFormats
+		case len(r.Fields) > 0:
+			route.formats = []LogFormat{VPCFlowFormat(r.Fields)}
+		default:
+			format, _ := LookupLogFormat(r.Format)
+			route.formats = []LogFormat{format}
+		}
+		p.routes = append(p.routes, route)
+	}
+	return p, nil
+}
+
+// Process replaces the payload of every recognised event with its fields
+func (p *Parser) Process(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	for _, event := range events {
+		route := p.route(event)
+		if route == nil {
+			continue
+		}
+		message := logMessage(event)
+		for _, format := range route.formats {
+			fields, ok := format.Parse(message)
+			if !ok {
+				continue
+			}
+			event.Event = fields
+			event.SourceType = format.SourceType
+			if route.sourceType != "" {
+				event.SourceType = route.sourceType
+			}
+			break
+		}
+	}
+	return events, nil
+}
+
+func (p *Parser) route(event *models.Event) *parseRoute {
+	for _, route := range p.routes {
+		matched := true
+		for field, re := range route.match {
+			if !re.MatchString(event.Field(field)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route
+		}
+	}
+	return nil
+}
+
+// logMessage returns the message of an extracted CloudWatch Logs event
+// ({"id", "timestamp", "message"}), or the whole payload otherwise
+func logMessage(event *models.Event) string {
+	if doc, ok := JSONPayload(event); ok {
+		if m, ok := doc.(map[string]interface{}); ok {
+			if message, ok := m["message"].(string); ok {
+				return message
+			}
+		}
+	}
+	return Payload(event)
+}
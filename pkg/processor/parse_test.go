@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+const (
+	vpcFlowLine    = "2 123456789010 eni-1235b8ca123456789 172.31.16.139 172.31.16.21 20641 22 6 20 4249 1418530010 1418530070 ACCEPT OK"
+	albLine        = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/ HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_1234`
+	nlbLine        = "tls 2.0 2018-12-20T02:59:40 net/my-network-loadbalancer/c6e77e28c25b2234 g3d4b5e8bb8464cd 72.21.218.154:51341 172.100.100.185:443 5 2 98 246 - arn:aws:acm:us-east-2:671290407336:certificate/2a108f19-aded-46b0-8493-c63eb1ef4a99 - ECDHE-RSA-AES128-SHA tlsv12 - my-network-loadbalancer-c6e77e28c25b2234.elb.us-east-2.amazonaws.com - - - 2018-12-20T02:59:30"
+	cloudFrontLine = "2019-12-04\t21:02:31\tLAX1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\t-\tMozilla/5.0\t-\t-\tHit\tSOX4xwn4XV6Q4rgb7XiVGOHms_BGlTAC4KyHmureZmBNrjGdRLiNIQ==\td111111abcdef8.cloudfront.net\thttps\t23\t0.001\t-\tTLSv1.2\tECDHE-RSA-AES128-GCM-SHA256\tHit\tHTTP/2.0\t-\t-\t11040\t0.001\tHit\ttext/html\t78\t-\t-"
+	route53Line    = `{"version":"1.100000","account_id":"123456789012","region":"us-east-1","vpc_id":"vpc-0123","query_timestamp":"2022-01-01T00:00:00Z","query_name":"example.com.","query_type":"A","query_class":"IN","rcode":"NOERROR","answers":[],"srcaddr":"10.0.0.5","srcport":"53","transport":"UDP"}`
+)
+
+func logEvent(message string) *models.Event {
+	data, _ := json.Marshal(map[string]interface{}{"id": "1", "timestamp": 1418530010000, "message": message})
+	return &models.Event{Event: string(data), SourceType: "aws:cloudwatch", Metadata: map[string]string{"loggroup": "/test"}}
+}
+
+func TestParser_AutoDetect(t *testing.T) {
+	p, err := NewParser([]ParseRoute{{Format: FormatAuto}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		line       string
+		sourceType string
+		field      string
+		expected   interface{}
+	}{
+		{vpcFlowLine, "aws:cloudwatchlogs:vpcflow", "dstport", int64(22)},
+		{albLine, "aws:elb:accesslogs", "request_url", "https://www.example.com:443/"},
+		{albLine, "aws:elb:accesslogs", "client_port", 2817},
+		{nlbLine, "aws:elb:accesslogs", "destination_ip", "172.100.100.185"},
+		{cloudFrontLine, "aws:cloudfront:accesslogs", "cs_host", "d111111abcdef8.cloudfront.net"},
+		{cloudFrontLine, "aws:cloudfront:accesslogs", "sc_status", int64(200)},
+		{route53Line, "aws:route53:resolver", "query_name", "example.com."},
+	}
+	for _, tt := range tests {
+		event := logEvent(tt.line)
+		p.Process(context.Background(), []*models.Event{event})
+		fields, ok := event.Event.(map[string]interface{})
+		if !ok {
+			t.Errorf("Expected %s to be parsed, got %v", tt.sourceType, event.Event)
+			continue
+		}
+		if event.SourceType != tt.sourceType {
+			t.Errorf("Expected sourcetype '%s', got '%s'", tt.sourceType, event.SourceType)
+		}
+		if fields[tt.field] != tt.expected {
+			t.Errorf("Expected %s to be %v, got %v (%T)", tt.field, tt.expected, fields[tt.field], fields[tt.field])
+		}
+	}
+
+	event := logEvent("START RequestId: 1234 Version: $LATEST")
+	p.Process(context.Background(), []*models.Event{event})
+	if _, ok := event.Event.(string); !ok || event.SourceType != "aws:cloudwatch" {
+		t.Errorf("Expected unrecognised event to be unchanged, got %v", event.Event)
+	}
+}
+
+func TestParser_Routes(t *testing.T) {
+	p, err := NewParser([]ParseRoute{
+		{
+			Match:      map[string]string{"loggroup": "^/vpc/custom"},
+			Format:     FormatVPCFlow,
+			Fields:     []string{"${vpc-id}", "${srcaddr}", "${dstaddr}", "${action}"},
+			SourceType: "vpc:custom",
+		},
+		{Match: map[string]string{"loggroup": "^/vpc/"}, Format: FormatVPCFlow},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	custom := &models.Event{Event: "vpc-0123 10.0.0.1 10.0.0.2 REJECT", Metadata: map[string]string{"loggroup": "/vpc/custom"}}
+	standard := &models.Event{Event: vpcFlowLine, Metadata: map[string]string{"loggroup": "/vpc/flow"}}
+	other := &models.Event{Event: vpcFlowLine, Metadata: map[string]string{"loggroup": "/aws/lambda/app"}}
+	p.Process(context.Background(), []*models.Event{custom, standard, other})
+
+	if fields, ok := custom.Event.(map[string]interface{}); !ok || fields["vpc_id"] != "vpc-0123" || custom.SourceType != "vpc:custom" {
+		t.Errorf("Expected custom format to be parsed, got %v (%s)", custom.Event, custom.SourceType)
+	}
+	if fields, ok := standard.Event.(map[string]interface{}); !ok || fields["log_status"] != "OK" {
+		t.Errorf("Expected default format to be parsed, got %v", standard.Event)
+	}
+	if _, ok := other.Event.(string); !ok {
+		t.Errorf("Expected unmatched event to be unchanged, got %v", other.Event)
+	}
+}
+
+func TestNewParser_Invalid(t *testing.T) {
+	invalid := []ParseRoute{
+		{Format: "syslog"},
+		{Format: FormatALB, Fields: []string{"version"}},
+		{Format: FormatAuto, Match: map[string]string{"loggroup": "("}},
+	}
+	for _, r := range invalid {
+		if _, err := NewParser([]ParseRoute{r}); err == nil {
+			t.Errorf("Expected error for %+v, got nil", r)
+		}
+	}
+}
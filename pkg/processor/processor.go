@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// Processor transforms a batch of events between provider parsing and HEC
+// delivery. It returns the events to keep, which may be fewer than given.
+type Processor interface {
+	Process(ctx context.Context, events []*models.Event) ([]*models.Event, error)
+}
+
+// Chain runs processors in order
+type Chain []Processor
+
+// Pipeline metrics, registered in metrics.Default
+var (
+	eventsIn = metrics.Default.Counter("whatthehec_pipeline_events_in_total",
+		"Events received from the cloud provider")
+	eventsDropped = metrics.Default.Counter("whatthehec_pipeline_events_dropped_total",
+		"Events dropped by processing before delivery")
+)
+
+// Process passes events through every processor in the chain
+func (c Chain) Process(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	received := len(events)
+	eventsIn.Add(float64(received))
+
+	var err error
+	for _, p := range c {
+		if events, err = p.Process(ctx, events); err != nil {
+			return nil, err
+		}
+	}
+	eventsDropped.Add(float64(received - len(events)))
+	return events, nil
+}
+
+// Payload returns the event payload as a string
+func Payload(event *models.Event) string {
+	switch v := event.Event.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
+
+// JSONPayload returns the event payload decoded as JSON, or false if it is
+// not JSON
+func JSONPayload(event *models.Event) (interface{}, bool) {
+	var raw []byte
+	switch v := event.Event.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	case map[string]interface{}, []interface{}:
+		return v, true
+	default:
+		return nil, false
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
@@ -0,0 +1,242 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+func TestLookup(t *testing.T) {
+	doc := map[string]interface{}{
+		"level": "DEBUG",
+		"detail": map[string]interface{}{
+			"status": float64(200),
+			"items":  []interface{}{map[string]interface{}{"name": "first"}},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected interface{}
+		found    bool
+	}{
+		{"$.level", "DEBUG", true},
+		{"level", "DEBUG", true},
+		{"$.detail.status", float64(200), true},
+		{"$.detail.items[0].name", "first", true},
+		{"$.detail.items[1].name", nil, false},
+		{"$.missing", nil, false},
+		{"$.level.deeper", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := Lookup(doc, tt.path)
+		if ok != tt.found || got != tt.expected {
+			t.Errorf("Lookup(%q): expected %v/%v, got %v/%v", tt.path, tt.expected, tt.found, got, ok)
+		}
+	}
+
+	if err := ValidatePath("$.items[x]"); err == nil {
+		t.Error("Expected error for invalid index, got nil")
+	}
+}
+
+func events(payloads ...interface{}) []*models.Event {
+	out := make([]*models.Event, len(payloads))
+	for i, p := range payloads {
+		out[i] = &models.Event{Event: p, Metadata: map[string]string{"loggroup": "/aws/lambda/app"}}
+	}
+	return out
+}
+
+func payloads(events []*models.Event) []string {
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = Payload(e)
+	}
+	return out
+}
+
+func TestFilter_Rules(t *testing.T) {
+	f, err := NewFilter([]FilterRoute{
+		{
+			Name:          "app",
+			Match:         map[string]string{"loggroup": "^/aws/lambda/"},
+			Exclude:       []string{"GET /health"},
+			ExcludeFields: map[string]string{"$.level": "DEBUG"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	in := events(
+		"GET /health 200",
+		`{"level": "DEBUG", "msg": "noise"}`,
+		`{"level": "INFO", "msg": "kept"}`,
+		"plain message",
+	)
+	other := &models.Event{Event: "GET /health 200", Metadata: map[string]string{"loggroup": "/ecs/web"}}
+	in = append(in, other)
+
+	out, err := f.Process(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := payloads(out)
+	if len(got) != 3 || got[0] != `{"level": "INFO", "msg": "kept"}` || got[1] != "plain message" || out[2] != other {
+		t.Errorf("Unexpected events kept: %v", got)
+	}
+
+	stats := f.Stats()
+	if len(stats) != 1 || stats[0].Route != "app" || stats[0].Filtered != 2 || stats[0].Sampled != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestFilter_Include(t *testing.T) {
+	f, err := NewFilter([]FilterRoute{{
+		Include:       []string{"ERROR", "WARN"},
+		IncludeFields: map[string]string{"$.service": "payments"},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out, _ := f.Process(context.Background(), events(
+		`{"service": "payments", "level": "ERROR"}`,
+		`{"service": "search", "level": "ERROR"}`,
+		`{"service": "payments", "level": "INFO"}`,
+		"ERROR not json",
+		map[string]interface{}{"service": "payments", "level": "WARN"},
+	))
+	if len(out) != 2 || Payload(out[0]) != `{"service": "payments", "level": "ERROR"}` {
+		t.Errorf("Unexpected events kept: %v", payloads(out))
+	}
+}
+
+func TestFilter_Sampling(t *testing.T) {
+	rate := 0.25
+	f, err := NewFilter([]FilterRoute{{Name: "sampled", SampleRate: &rate}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var in []interface{}
+	for i := 0; i < 4000; i++ {
+		in = append(in, fmt.Sprintf("event %d", i))
+	}
+	out, _ := f.Process(context.Background(), events(in...))
+	if len(out) < 800 || len(out) > 1200 {
+		t.Errorf("Expected about 1000 of 4000 events, got %d", len(out))
+	}
+	if stats := f.Stats(); stats[0].Sampled != uint64(4000-len(out)) {
+		t.Errorf("Expected sampled count %d, got %d", 4000-len(out), stats[0].Sampled)
+	}
+}
+
+func TestFilter_SamplingDeterministic(t *testing.T) {
+	rate := 0.5
+	routes := []FilterRoute{{Name: "sampled", SampleRate: &rate}}
+
+	var in []interface{}
+	for i := 0; i < 200; i++ {
+		in = append(in, fmt.Sprintf("event %d", i))
+	}
+	// Another instance, or a retry in a later batch, keeps the same events
+	first, _ := NewFilter(routes)
+	second, _ := NewFilter(routes)
+	want, _ := first.Process(context.Background(), events(in...))
+	first.Process(context.Background(), events(in...))
+	got, _ := second.Process(context.Background(), events(in...))
+	if !reflect.DeepEqual(payloads(got), payloads(want)) {
+		t.Errorf("Expected the same events to be kept, got %d and %d", len(got), len(want))
+	}
+
+	// Repeats of a payload share one decision
+	repeats := make([]interface{}, 100)
+	for i := range repeats {
+		repeats[i] = "connection reset by peer"
+	}
+	if out, _ := first.Process(context.Background(), events(repeats...)); len(out) != 0 && len(out) != 100 {
+		t.Errorf("Expected identical events to be all kept or all dropped, got %d of 100", len(out))
+	}
+}
+
+func TestFilter_SampleKey(t *testing.T) {
+	rate := 0.5
+	f, err := NewFilter([]FilterRoute{{Name: "requests", SampleRate: &rate, SampleKey: "$.requestId"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	kept := 0
+	for i := 0; i < 50; i++ {
+		var in []interface{}
+		for j := 0; j < 4; j++ {
+			in = append(in, fmt.Sprintf(`{"requestId":"req-%d","line":%d}`, i, j))
+		}
+		out, _ := f.Process(context.Background(), events(in...))
+		if len(out) != 0 && len(out) != 4 {
+			t.Fatalf("Expected the events of request %d to be kept or dropped together, got %d of 4", i, len(out))
+		}
+		kept += len(out) / 4
+	}
+	if kept == 0 || kept == 50 {
+		t.Errorf("Expected some of 50 requests to be sampled out, kept %d", kept)
+	}
+
+	if _, err := NewFilter([]FilterRoute{{SampleRate: &rate, SampleKey: "$.a["}}); err == nil {
+		t.Error("Expected error for an invalid sample key path, got nil")
+	}
+}
+
+func TestFilter_SampleRateUnset(t *testing.T) {
+	none := 0.0
+	f, err := NewFilter([]FilterRoute{
+		{Match: map[string]string{"index": "^debug$"}, SampleRate: &none},
+		{Exclude: []string{"drop"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out, _ := f.Process(context.Background(), []*models.Event{
+		{Index: "debug", Event: "a"},
+		{Index: "main", Event: "b"},
+		{Index: "main", Event: "c"},
+	})
+	if len(out) != 2 {
+		t.Errorf("Expected a rate of 0 to drop every event and no rate to keep all, got %v", payloads(out))
+	}
+}
+
+func TestNewFilter_Invalid(t *testing.T) {
+	tooHigh := 1.5
+	invalid := []FilterRoute{
+		{Match: map[string]string{"index": "("}},
+		{Exclude: []string{"["}},
+		{IncludeFields: map[string]string{"$.a[": "x"}},
+		{SampleRate: &tooHigh},
+	}
+	for _, route := range invalid {
+		if _, err := NewFilter([]FilterRoute{route}); err == nil {
+			t.Errorf("Expected error for %+v, got nil", route)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	drop, _ := NewFilter([]FilterRoute{{Exclude: []string{"drop"}}})
+	keepErrors, _ := NewFilter([]FilterRoute{{Include: []string{"error"}}})
+
+	out, err := Chain{drop, keepErrors}.Process(context.Background(), events("drop error", "keep error", "keep info"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(out) != 1 || Payload(out[0]) != "keep error" {
+		t.Errorf("Unexpected events kept: %v", payloads(out))
+	}
+}
@@ -0,0 +1,272 @@
+package processor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// Redacted replaces masked values unless a rule sets its own replacement
+const Redacted = "[REDACTED]"
+
+// RedactRule replaces every match of Pattern with Replacement, which may
+// refer to capture groups as ${1}
+type RedactRule struct {
+	Name        string
+	Pattern     string
+	Replacement string
+	// Validate, when set, is called with each match and the match is only
+	// replaced if it returns true
+	Validate func(string) bool
+}
+
+// BuiltinRedactRules are common secrets and PII, selectable by name
+var BuiltinRedactRules = map[string]RedactRule{
+	"bearer_token": {
+		Pattern:     `(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`,
+		Replacement: "${1}" + Redacted,
+	},
+	"basic_auth": {
+		Pattern:     `(?i)(basic\s+)[A-Za-z0-9+/]{8,}=*`,
+		Replacement: "${1}" + Redacted,
+	},
+	"splunk_token": {
+		Pattern:     `(?i)(splunk\s+)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`,
+		Replacement: "${1}" + Redacted,
+	},
+	"jwt": {
+		Pattern: `\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+	},
+	"aws_access_key": {
+		Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	},
+	"aws_secret_key": {
+		Pattern:     `(?i)(aws_?secret_?access_?key["']?\s*[:=]\s*["']?)[A-Za-z0-9/+=]{40}`,
+		Replacement: "${1}" + Redacted,
+	},
+	"private_key": {
+		Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	},
+	"password": {
+		Pattern:     `(?i)\b(password|passwd|pwd|secret|api[_-]?key|access[_-]?token)(["']?\s*[:=]\s*["']?)[^"'\s,;&]+`,
+		Replacement: "${1}${2}" + Redacted,
+	},
+	"email": {
+		Pattern: `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+	},
+	"credit_card": {
+		Pattern:  `\b\d(?:[ -]?\d){12,18}\b`,
+		Validate: luhn,
+	},
+}
+
+// RedactConfig holds redaction settings
+type RedactConfig struct {
+	Builtin    []string     // names from BuiltinRedactRules, or "all"
+	Rules      []RedactRule // custom regex replacements
+	DeleteKeys []string     // JSON keys removed wherever they appear, case-insensitive
+	HashKeys   []string     // JSON keys replaced by a keyed HMAC-SHA256, case-insensitive
+	HMACKey    string       // key for HashKeys
+}
+
+// Redactor masks secrets and PII in event payloads. Regex rules apply to
+// plain text payloads and to every string value of JSON payloads.
+type Redactor struct {
+	rules      []compiledRule
+	deleteKeys map[string]bool
+	hashKeys   map[string]bool
+	hmacKey    []byte
+}
+
+type compiledRule struct {
+	re          *regexp.Regexp
+	replacement string
+	validate    func(string) bool
+}
+
+// NewRedactor compiles the redaction rules
+func NewRedactor(cfg RedactConfig) (*Redactor, error) {
+	r := &Redactor{
+		deleteKeys: lowerSet(cfg.DeleteKeys),
+		hashKeys:   lowerSet(cfg.HashKeys),
+		hmacKey:    []byte(cfg.HMACKey),
+	}
+	if len(r.hashKeys) > 0 && cfg.HMACKey == "" {
+		return nil, fmt.Errorf("an HMAC key is required to hash keys")
+	}
+
+	var rules []RedactRule
+	for _, name := range cfg.Builtin {
+		if name == "all" {
+			names := make([]string, 0, len(BuiltinRedactRules))
+			for n := range BuiltinRedactRules {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				rules = append(rules, BuiltinRedactRules[n])
+			}
+			continue
+		}
+		rule, ok := BuiltinRedactRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown built-in redaction rule %q", name)
+		}
+		rules = append(rules, rule)
+	}
+	rules = append(rules, cfg.Rules...)
+
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %s: %w", rule.Name, err)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = Redacted
+		}
+		r.rules = append(r.rules, compiledRule{re: re, replacement: replacement, validate: rule.Validate})
+	}
+	return r, nil
+}
+
+// Process redacts every event in place
+func (r *Redactor) Process(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	for _, event := range events {
+		r.redactEvent(event)
+	}
+	return events, nil
+}
+
+func (r *Redactor) redactEvent(event *models.Event) {
+	switch v := event.Event.(type) {
+	case string:
+		event.Event = r.redactText(v)
+	case []byte:
+		event.Event = r.redactText(string(v))
+	case map[string]interface{}, []interface{}:
+		event.Event = r.redactJSON(v)
+	}
+}
+
+// redactText redacts a text payload, treating JSON objects and arrays
+// structurally. JSON is only re-encoded when something changed, keeping
+// the original formatting otherwise.
+func (r *Redactor) redactText(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(trimmed), &doc); err == nil {
+			redacted := r.redactJSON(doc)
+			if jsonEqual(doc, redacted) {
+				return s
+			}
+			if data, err := json.Marshal(redacted); err == nil {
+				return string(data)
+			}
+		}
+	}
+	return r.redactString(s)
+}
+
+// redactJSON returns a redacted copy of a decoded JSON value
+func (r *Redactor) redactJSON(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(node))
+		for key, value := range node {
+			lower := strings.ToLower(key)
+			switch {
+			case r.deleteKeys[lower]:
+			case r.hashKeys[lower]:
+				out[key] = r.hash(value)
+			default:
+				out[key] = r.redactJSON(value)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(node))
+		for i, value := range node {
+			out[i] = r.redactJSON(value)
+		}
+		return out
+	case string:
+		return r.redactString(node)
+	default:
+		return v
+	}
+}
+
+func (r *Redactor) redactString(s string) string {
+	for _, rule := range r.rules {
+		if rule.validate == nil {
+			s = rule.re.ReplaceAllString(s, rule.replacement)
+			continue
+		}
+		s = rule.re.ReplaceAllStringFunc(s, func(match string) string {
+			if !rule.validate(match) {
+				return match
+			}
+			return rule.re.ReplaceAllString(match, rule.replacement)
+		})
+	}
+	return s
+}
+
+// hash returns the hex HMAC-SHA256 of a value, so equal values can still
+// be correlated without revealing them
+func (r *Redactor) hash(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	mac := hmac.New(sha256.New, r.hmacKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func jsonEqual(a, b interface{}) bool {
+	da, errA := json.Marshal(a)
+	db, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(da) == string(db)
+}
+
+func lowerSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = true
+	}
+	return set
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by
+// payment card numbers
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lambda platform log handling modes
+const (
+	PlatformLogsKeep    = "keep"    // forward START/END/REPORT lines unchanged
+	PlatformLogsDrop    = "drop"    // drop them
+	PlatformLogsMetrics = "metrics" // drop START/END and convert REPORT to a metric event
+)
+
+// ReportSourceType is the sourcetype of events converted from REPORT lines
+const ReportSourceType = "aws:lambda:report"
+
+// Lambda platform line types
+const (
+	platformStart  = "platform.start"
+	platformEnd    = "platform.end"
+	platformReport = "platform.report"
+	platformOther  = "platform"
+)
+
+var (
+	// "REPORT RequestId: <id>\tDuration: 2.34 ms\tBilled Duration: 3 ms\t..."
+	reportField = regexp.MustCompile(`([A-Za-z ]+): ([^\t]+?)(?: ms| MB)?(?:\t|$)`)
+
+	// "<time>\t<request id>\t<level>\t<message>" (Node.js and others)
+	textLogLine = regexp.MustCompile(`^\S+\t([0-9a-f-]{36})\t([A-Z]+)\t`)
+	// "[<level>]\t<time>\t<request id>\t<message>" (Python)
+	pythonLogLine = regexp.MustCompile(`^\[([A-Z]+)\]\t\S+\t([0-9a-f-]{36})\t`)
+)
+
+// reportMetrics maps REPORT line fields to metric names
+var reportMetrics = map[string]string{
+	"Duration":        "durationMs",
+	"Billed Duration": "billedDurationMs",
+	"Memory Size":     "memorySizeMB",
+	"Max Memory Used": "maxMemoryUsedMB",
+	"Init Duration":   "initDurationMs",
+}
+
+// platformLine returns the type of a Lambda platform line, in either the
+// text or JSON log format, or "" for application logs
+func platformLine(message string) string {
+	switch {
+	case strings.HasPrefix(message, "START RequestId:"):
+		return platformStart
+	case strings.HasPrefix(message, "END RequestId:"):
+		return platformEnd
+	case strings.HasPrefix(message, "REPORT RequestId:"):
+		return platformReport
+	case strings.HasPrefix(message, "INIT_START "), strings.HasPrefix(message, "INIT_REPORT "):
+		return platformOther
+	case strings.HasPrefix(message, "{") && strings.Contains(message, `"platform.`):
+		var line struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal([]byte(message), &line) == nil && strings.HasPrefix(line.Type, "platform.") {
+			switch line.Type {
+			case platformStart, platformEnd, platformReport:
+				return line.Type
+			}
+			return platformOther
+		}
+	}
+	return ""
+}
+
+// reportEvent converts a REPORT line to a metric event with the request
+// ID, durations in milliseconds and memory in MB
+func reportEvent(message, logGroup string) map[string]interface{} {
+	event := map[string]interface{}{"type": platformReport}
+	if name, ok := strings.CutPrefix(logGroup, "/aws/lambda/"); ok {
+		event["functionName"] = name
+	}
+
+	if strings.HasPrefix(message, "{") {
+		var line struct {
+			Record struct {
+				RequestID string             `json:"requestId"`
+				Status    string             `json:"status"`
+				Metrics   map[string]float64 `json:"metrics"`
+			} `json:"record"`
+		}
+		if err := json.Unmarshal([]byte(message), &line); err == nil {
+			event["requestId"] = line.Record.RequestID
+			if line.Record.Status != "" {
+				event["status"] = line.Record.Status
+			}
+			for name, value := range line.Record.Metrics {
+				event[name] = value
+			}
+		}
+		return event
+	}
+
+	for _, m := range reportField.FindAllStringSubmatch(strings.TrimPrefix(message, "REPORT "), -1) {
+		key, value := strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+		if key == "RequestId" {
+			event["requestId"] = value
+			continue
+		}
+		if name, ok := reportMetrics[key]; ok {
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				event[name] = n
+			}
+		}
+	}
+	return event
+}
+
+// lambdaFields returns the level and request ID of a Lambda application
+// log line, in the JSON log format or the default text formats
+func lambdaFields(message string) map[string]string {
+	fields := make(map[string]string)
+	if strings.HasPrefix(message, "{") {
+		var line struct {
+			Level     string `json:"level"`
+			RequestID string `json:"requestId"`
+		}
+		if json.Unmarshal([]byte(message), &line) == nil {
+			if line.Level != "" {
+				fields["level"] = line.Level
+			}
+			if line.RequestID != "" {
+				fields["requestid"] = line.RequestID
+			}
+		}
+	} else if m := textLogLine.FindStringSubmatch(message); m != nil {
+		fields["requestid"], fields["level"] = m[1], m[2]
+	} else if m := pythonLogLine.FindStringSubmatch(message); m != nil {
+		fields["level"], fields["requestid"] = m[1], m[2]
+	}
+	return fields
+}
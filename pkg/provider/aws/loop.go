@@ -0,0 +1,134 @@
+package aws
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+)
+
+// loopDropped counts events dropped by the loop guard, by reason (denylist
+// or breaker)
+var loopDropped = metrics.Default.Counter("whatthehec_loop_events_dropped_total",
+	"Events dropped to prevent a forwarding loop, by reason (denylist or breaker)", "reason")
+
+// LoopGuardConfig holds the rules that stop the forwarder from forwarding
+// its own logs
+type LoopGuardConfig struct {
+	// DenyLogGroups are log groups whose events are always dropped, such as
+	// the forwarder's own. Entries ending in "*" match by prefix.
+	DenyLogGroups []string
+	// MaxEvents is the number of events a log group may send per Window
+	// before its breaker opens; 0 disables the breaker
+	MaxEvents int
+	Window    time.Duration
+	// Cooldown is how long events from a log group are dropped once its
+	// breaker opens
+	Cooldown time.Duration
+}
+
+// LoopGuard drops events from denied log groups and from log groups that
+// exceed a rate, which happens when the forwarder's log group is subscribed
+// to the forwarder. Rates are kept across invocations of a warm function.
+type LoopGuard struct {
+	deny      []string
+	maxEvents int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*groupRate
+}
+
+// groupRate counts the events of one log group in the current window
+type groupRate struct {
+	start     time.Time
+	count     int
+	openUntil time.Time
+}
+
+// NewLoopGuard creates a loop guard, returning nil if no rule is set
+func NewLoopGuard(cfg LoopGuardConfig) (*LoopGuard, error) {
+	var deny []string
+	for _, group := range cfg.DenyLogGroups {
+		if group = strings.TrimSpace(group); group != "" {
+			deny = append(deny, group)
+		}
+	}
+	if len(deny) == 0 && cfg.MaxEvents == 0 {
+		return nil, nil
+	}
+	if cfg.MaxEvents < 0 || cfg.Window < 0 || cfg.Cooldown < 0 {
+		return nil, fmt.Errorf("loop guard limits must not be negative")
+	}
+	if cfg.MaxEvents > 0 && cfg.Window == 0 {
+		return nil, fmt.Errorf("loop guard window must be set with a maximum event count")
+	}
+
+	return &LoopGuard{
+		deny:      deny,
+		maxEvents: cfg.MaxEvents,
+		window:    cfg.Window,
+		cooldown:  cfg.Cooldown,
+		now:       time.Now,
+		groups:    make(map[string]*groupRate),
+	}, nil
+}
+
+// Allow reports whether a batch of events from logGroup may be forwarded,
+// counting them towards the log group's rate
+func (g *LoopGuard) Allow(logGroup string, events int) bool {
+	if g == nil {
+		return true
+	}
+	if g.denied(logGroup) {
+		loopDropped.Add(float64(events), "denylist")
+		return false
+	}
+	if g.maxEvents == 0 {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	rate, ok := g.groups[logGroup]
+	if !ok {
+		rate = &groupRate{start: now}
+		g.groups[logGroup] = rate
+	}
+	if now.Before(rate.openUntil) {
+		loopDropped.Add(float64(events), "breaker")
+		return false
+	}
+	if now.Sub(rate.start) >= g.window {
+		rate.start, rate.count = now, 0
+	}
+	rate.count += events
+	if rate.count > g.maxEvents {
+		rate.openUntil = now.Add(g.cooldown)
+		rate.start, rate.count = rate.openUntil, 0
+		slog.Warn("Log group exceeded the event rate, dropping its events",
+			"log_group", logGroup, "max_events", g.maxEvents, "window", g.window, "cooldown", g.cooldown)
+		loopDropped.Add(float64(events), "breaker")
+		return false
+	}
+	return true
+}
+
+func (g *LoopGuard) denied(logGroup string) bool {
+	for _, deny := range g.deny {
+		if prefix, ok := strings.CutSuffix(deny, "*"); ok {
+			if strings.HasPrefix(logGroup, prefix) {
+				return true
+			}
+		} else if logGroup == deny {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package aws
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// parseMessage decodes a JSON object or logfmt log message, returning nil
+// for any other message
+func parseMessage(message string) map[string]interface{} {
+	trimmed := strings.TrimSpace(message)
+	if strings.HasPrefix(trimmed, "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(trimmed), &fields); err == nil {
+			return fields
+		}
+		return nil
+	}
+	return parseLogfmt(trimmed)
+}
+
+// parseLogfmt decodes key=value pairs separated by spaces, where values may
+// be double-quoted. It returns nil unless the whole message is at least two
+// pairs, so plain text containing an "=" is not mistaken for logfmt.
+func parseLogfmt(message string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for i := 0; i < len(message); {
+		if message[i] == ' ' {
+			i++
+			continue
+		}
+
+		eq := strings.IndexAny(message[i:], "= \"")
+		if eq <= 0 || message[i+eq] != '=' {
+			return nil
+		}
+		key := message[i : i+eq]
+		i += eq + 1
+
+		var value string
+		if i < len(message) && message[i] == '"' {
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(message) && message[j] != '"'; j++ {
+				if message[j] == '\\' && j+1 < len(message) {
+					j++
+				}
+				b.WriteByte(message[j])
+			}
+			if j >= len(message) {
+				return nil
+			}
+			value = b.String()
+			i = j + 1
+		} else {
+			j := strings.IndexByte(message[i:], ' ')
+			if j < 0 {
+				j = len(message) - i
+			}
+			value = message[i : i+j]
+			i += j
+		}
+		if i < len(message) && message[i] != ' ' {
+			return nil
+		}
+		fields[key] = value
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MultilineConfig holds the rules for joining log events. A log event is
+// appended to the previous one when it matches ContinuationPattern, or when
+// StartPattern is set and it does not match it.
+type MultilineConfig struct {
+	StartPattern        string
+	ContinuationPattern string
+	MaxLines            int // lines per joined event, 0 for no limit
+	MaxBytes            int // message bytes per joined event, 0 for no limit
+}
+
+// Multiline joins consecutive log events of one log stream, such as the
+// lines of a stack trace. It keeps no state between batches, so an event
+// split across two invocations is not joined.
+type Multiline struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	maxLines     int
+	maxBytes     int
+}
+
+// NewMultiline compiles the rules, returning nil if no pattern is set
+func NewMultiline(cfg MultilineConfig) (*Multiline, error) {
+	if cfg.StartPattern == "" && cfg.ContinuationPattern == "" {
+		return nil, nil
+	}
+	if cfg.MaxLines < 0 || cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("multiline limits must not be negative")
+	}
+
+	m := &Multiline{maxLines: cfg.MaxLines, maxBytes: cfg.MaxBytes}
+	var err error
+	if cfg.StartPattern != "" {
+		if m.start, err = regexp.Compile(cfg.StartPattern); err != nil {
+			return nil, fmt.Errorf("multiline start pattern: %w", err)
+		}
+	}
+	if cfg.ContinuationPattern != "" {
+		if m.continuation, err = regexp.Compile(cfg.ContinuationPattern); err != nil {
+			return nil, fmt.Errorf("multiline continuation pattern: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Aggregate joins continuation events into the event before them, with
+// the ID and timestamp of the first event and messages separated by
+// newlines
+func (m *Multiline) Aggregate(events []LogEvent) []LogEvent {
+	if m == nil || len(events) == 0 {
+		return events
+	}
+
+	joined := make([]LogEvent, 0, len(events))
+	lines := 0
+	for _, event := range events {
+		if len(joined) > 0 && m.continues(event.Message) {
+			last := &joined[len(joined)-1]
+			head := strings.TrimSuffix(last.Message, "\n")
+			line := strings.TrimSuffix(event.Message, "\n")
+			fits := (m.maxLines == 0 || lines < m.maxLines) &&
+				(m.maxBytes == 0 || len(head)+1+len(line) <= m.maxBytes)
+			if fits {
+				last.Message = head + "\n" + line
+				lines++
+				continue
+			}
+		}
+		joined = append(joined, event)
+		lines = 1
+	}
+	return joined
+}
+
+func (m *Multiline) continues(message string) bool {
+	if m.continuation != nil && m.continuation.MatchString(message) {
+		return true
+	}
+	return m.start != nil && !m.start.MatchString(message)
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/mosajjal/whatthehec/pkg/models"
 	"github.com/mosajjal/whatthehec/pkg/provider"
@@ -16,12 +17,49 @@ import (
 // Provider implements the CloudProvider interface for AWS
 type Provider struct {
 	extractLogEvents bool
+	embedMessages    bool
+	messageOnly      bool
+	platformLogs     string
+	multiline        *Multiline
+	loopGuard        *LoopGuard
+}
+
+// Options holds AWS provider settings
+type Options struct {
+	// ExtractLogEvents emits each CloudWatch log event separately
+	ExtractLogEvents bool
+	// EmbedMessages embeds JSON and logfmt messages of extracted log events
+	// as objects instead of strings
+	EmbedMessages bool
+	// MessageOnly emits only the message of extracted log events, without
+	// the id and timestamp
+	MessageOnly bool
+	// PlatformLogs handles the START, END and REPORT lines of Lambda log
+	// groups: PlatformLogsKeep (the default), PlatformLogsDrop or
+	// PlatformLogsMetrics
+	PlatformLogs string
+	// Multiline joins consecutive log events before they are converted;
+	// nil disables it
+	Multiline *Multiline
+	// LoopGuard drops events from the forwarder's own and other denied log
+	// groups; nil disables it
+	LoopGuard *LoopGuard
 }
 
 // NewProvider creates a new AWS provider
 func NewProvider(extractLogEvents bool) provider.CloudProvider {
+	return NewProviderWithOptions(Options{ExtractLogEvents: extractLogEvents})
+}
+
+// NewProviderWithOptions creates a new AWS provider with the given options
+func NewProviderWithOptions(opts Options) provider.CloudProvider {
 	return &Provider{
-		extractLogEvents: extractLogEvents,
+		extractLogEvents: opts.ExtractLogEvents,
+		embedMessages:    opts.EmbedMessages,
+		messageOnly:      opts.MessageOnly,
+		platformLogs:     opts.PlatformLogs,
+		multiline:        opts.Multiline,
+		loopGuard:        opts.LoopGuard,
 	}
 }
 
@@ -87,6 +125,13 @@ func (p *Provider) ParseEvent(ctx context.Context, rawEvent interface{}) (*model
 
 // ParseBatch parses a batch of AWS events (for Kinesis)
 func (p *Provider) ParseBatch(ctx context.Context, rawEvent interface{}) ([]*models.CloudEvent, error) {
+	ctx, span := provider.StartParseSpan(ctx, "aws")
+	events, err := p.parseBatch(ctx, rawEvent)
+	provider.EndParseSpan(span, len(events), err)
+	return events, err
+}
+
+func (p *Provider) parseBatch(ctx context.Context, rawEvent interface{}) ([]*models.CloudEvent, error) {
 	data, err := json.Marshal(rawEvent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal event: %w", err)
@@ -106,6 +151,12 @@ func (p *Provider) ParseBatch(ctx context.Context, rawEvent interface{}) ([]*mod
 			if err != nil {
 				continue
 			}
+			if p.loopGuard != nil {
+				var cwData CloudWatchLogsData
+				if json.Unmarshal(decodedData, &cwData) == nil && !p.loopGuard.Allow(cwData.LogGroup, len(cwData.LogEvents)) {
+					continue
+				}
+			}
 			events = append(events, &models.CloudEvent{
 				ProviderType: "aws",
 				RawData:      decodedData,
@@ -121,20 +172,22 @@ func (p *Provider) ParseBatch(ctx context.Context, rawEvent interface{}) ([]*mod
 			return nil, fmt.Errorf("failed to decode CloudWatch data: %w", err)
 		}
 
+		var cwData CloudWatchLogsData
+		decoded := false
+		if p.extractLogEvents || p.loopGuard != nil {
+			decoded = json.Unmarshal(decodedData, &cwData) == nil
+		}
+		if decoded && !p.loopGuard.Allow(cwData.LogGroup, len(cwData.LogEvents)) {
+			return nil, nil
+		}
+
 		// If extractLogEvents is enabled, parse individual log events
 		if p.extractLogEvents {
-			var cwData CloudWatchLogsData
-			if err := json.Unmarshal(decodedData, &cwData); err == nil && len(cwData.LogEvents) > 0 {
-				for _, logEvent := range cwData.LogEvents {
-					eventData, _ := json.Marshal(logEvent)
-					events = append(events, &models.CloudEvent{
-						ProviderType: "aws",
-						Timestamp:    logEvent.Timestamp,
-						LogGroup:     cwData.LogGroup,
-						LogStream:    cwData.LogStream,
-						Message:      logEvent.Message,
-						RawData:      eventData,
-					})
+			if decoded && len(cwData.LogEvents) > 0 {
+				for _, logEvent := range p.multiline.Aggregate(cwData.LogEvents) {
+					if event := p.logEvent(cwData, logEvent); event != nil {
+						events = append(events, event)
+					}
 				}
 				return events, nil
 			}
@@ -149,6 +202,59 @@ func (p *Provider) ParseBatch(ctx context.Context, rawEvent interface{}) ([]*mod
 	return events, nil
 }
 
+// logEvent converts one extracted CloudWatch log event, returning nil if
+// it is dropped
+func (p *Provider) logEvent(cwData CloudWatchLogsData, logEvent LogEvent) *models.CloudEvent {
+	event := &models.CloudEvent{
+		ProviderType: "aws",
+		Timestamp:    logEvent.Timestamp,
+		LogGroup:     cwData.LogGroup,
+		LogStream:    cwData.LogStream,
+		Message:      logEvent.Message,
+	}
+
+	if p.platformLogs != "" && p.platformLogs != PlatformLogsKeep {
+		message := strings.TrimSpace(logEvent.Message)
+		switch platformLine(message) {
+		case "":
+		case platformReport:
+			if p.platformLogs != PlatformLogsMetrics {
+				return nil
+			}
+			event.RawData, _ = json.Marshal(logEvent)
+			event.Payload = reportEvent(message, cwData.LogGroup)
+			event.Metadata = map[string]string{"sourcetype": ReportSourceType}
+			return event
+		default:
+			return nil
+		}
+	}
+	event.Metadata = lambdaFields(logEvent.Message)
+
+	var embedded map[string]interface{}
+	if p.embedMessages {
+		embedded = parseMessage(logEvent.Message)
+	}
+
+	switch {
+	case p.messageOnly:
+		event.RawData = []byte(logEvent.Message)
+		if embedded != nil {
+			event.Payload = embedded
+		}
+	case embedded != nil:
+		event.RawData, _ = json.Marshal(logEvent)
+		event.Payload = map[string]interface{}{
+			"id":        logEvent.ID,
+			"timestamp": logEvent.Timestamp,
+			"message":   embedded,
+		}
+	default:
+		event.RawData, _ = json.Marshal(logEvent)
+	}
+	return event
+}
+
 func decodeCloudWatchData(data string) ([]byte, error) {
 	// Decode base64
 	base64Decoded, err := base64.StdEncoding.DecodeString(data)
@@ -1,9 +1,13 @@
 package aws
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestProvider_Name(t *testing.T) {
@@ -89,3 +93,221 @@ func TestProvider_ExtractLogEvents(t *testing.T) {
 		t.Error("Expected extractLogEvents to be true")
 	}
 }
+
+// cloudWatchEvent encodes log messages as a CloudWatch Logs subscription event
+func cloudWatchEvent(t *testing.T, messages ...string) map[string]interface{} {
+	data := CloudWatchLogsData{MessageType: "DATA_MESSAGE", LogGroup: "/aws/lambda/app", LogStream: "stream"}
+	for i, message := range messages {
+		data.LogEvents = append(data.LogEvents, LogEvent{ID: "id", Timestamp: int64(1700000000000 + i), Message: message})
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(raw)
+	gz.Close()
+	return map[string]interface{}{
+		"awslogs": map[string]interface{}{"data": base64.StdEncoding.EncodeToString(buf.Bytes())},
+	}
+}
+
+func TestProvider_EmbedMessages(t *testing.T) {
+	event := cloudWatchEvent(t, `{"level":"INFO","msg":"started"}`, `level=warn msg="disk full" free=0`, "plain text")
+
+	provider := NewProviderWithOptions(Options{ExtractLogEvents: true, EmbedMessages: true})
+	events, err := provider.ParseBatch(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	wrapper, ok := events[0].Payload.(map[string]interface{})
+	if !ok || wrapper["timestamp"] != int64(1700000000000) {
+		t.Fatalf("Expected id/timestamp wrapper, got %v", events[0].Payload)
+	}
+	if message, ok := wrapper["message"].(map[string]interface{}); !ok || message["level"] != "INFO" {
+		t.Errorf("Expected JSON message to be embedded, got %v", wrapper["message"])
+	}
+	if events[2].Payload != nil {
+		t.Errorf("Expected plain text to be left as a string, got %v", events[2].Payload)
+	}
+
+	provider = NewProviderWithOptions(Options{ExtractLogEvents: true, EmbedMessages: true, MessageOnly: true})
+	events, _ = provider.ParseBatch(context.Background(), event)
+	if fields, ok := events[1].Payload.(map[string]interface{}); !ok || fields["msg"] != "disk full" {
+		t.Errorf("Expected logfmt message to be embedded on its own, got %v", events[1].Payload)
+	}
+	if string(events[2].RawData) != "plain text" || events[2].Timestamp != 1700000000002 {
+		t.Errorf("Expected only the message with its timestamp, got '%s' (%d)", events[2].RawData, events[2].Timestamp)
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		message string
+		fields  int
+	}{
+		{`level=info msg="hello world" status=200`, 3},
+		{`a="quoted \"value\"" b=`, 2},
+		{"single=pair", 0},
+		{"error: x=1 failed", 0},
+		{`a="unterminated b=1`, 0},
+	}
+	for _, tt := range tests {
+		if got := parseLogfmt(tt.message); len(got) != tt.fields {
+			t.Errorf("parseLogfmt(%q): expected %d fields, got %v", tt.message, tt.fields, got)
+		}
+	}
+}
+
+func TestProvider_PlatformLogs(t *testing.T) {
+	event := cloudWatchEvent(t,
+		"START RequestId: 8f5c2a3e-1b2c-4d5e-8f90-123456789abc Version: $LATEST\n",
+		"2024-01-01T00:00:00.000Z\t8f5c2a3e-1b2c-4d5e-8f90-123456789abc\tERROR\tsomething failed\n",
+		`{"timestamp":"2024-01-01T00:00:00Z","level":"WARN","requestId":"req-1","message":"slow"}`,
+		"END RequestId: 8f5c2a3e-1b2c-4d5e-8f90-123456789abc\n",
+		"REPORT RequestId: 8f5c2a3e-1b2c-4d5e-8f90-123456789abc\tDuration: 2.34 ms\tBilled Duration: 3 ms\tMemory Size: 128 MB\tMax Memory Used: 70 MB\tInit Duration: 150.12 ms\t\n",
+		`{"time":"2024-01-01T00:00:01Z","type":"platform.report","record":{"requestId":"req-1","metrics":{"durationMs":10.5,"billedDurationMs":11,"memorySizeMB":128,"maxMemoryUsedMB":64},"status":"success"}}`,
+	)
+
+	keep, _ := NewProviderWithOptions(Options{ExtractLogEvents: true}).ParseBatch(context.Background(), event)
+	if len(keep) != 6 {
+		t.Errorf("Expected all 6 events to be kept, got %d", len(keep))
+	}
+	if keep[1].Metadata["level"] != "ERROR" || keep[2].Metadata["requestid"] != "req-1" {
+		t.Errorf("Expected level and request ID metadata, got %v and %v", keep[1].Metadata, keep[2].Metadata)
+	}
+
+	drop, _ := NewProviderWithOptions(Options{ExtractLogEvents: true, PlatformLogs: PlatformLogsDrop}).ParseBatch(context.Background(), event)
+	if len(drop) != 2 {
+		t.Errorf("Expected only application logs, got %d events", len(drop))
+	}
+
+	metrics, _ := NewProviderWithOptions(Options{ExtractLogEvents: true, PlatformLogs: PlatformLogsMetrics}).ParseBatch(context.Background(), event)
+	if len(metrics) != 4 {
+		t.Fatalf("Expected application logs and 2 reports, got %d events", len(metrics))
+	}
+	text, ok := metrics[2].Payload.(map[string]interface{})
+	if !ok || metrics[2].Metadata["sourcetype"] != ReportSourceType {
+		t.Fatalf("Expected report metric event, got %v", metrics[2].Payload)
+	}
+	expected := map[string]interface{}{
+		"requestId":        "8f5c2a3e-1b2c-4d5e-8f90-123456789abc",
+		"functionName":     "app",
+		"durationMs":       2.34,
+		"billedDurationMs": float64(3),
+		"memorySizeMB":     float64(128),
+		"maxMemoryUsedMB":  float64(70),
+		"initDurationMs":   150.12,
+	}
+	for k, v := range expected {
+		if text[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, text[k])
+		}
+	}
+	if jsonReport, _ := metrics[3].Payload.(map[string]interface{}); jsonReport["durationMs"] != 10.5 || jsonReport["status"] != "success" {
+		t.Errorf("Expected JSON report metrics, got %v", metrics[3].Payload)
+	}
+}
+
+func TestMultiline_Aggregate(t *testing.T) {
+	m, err := NewMultiline(MultilineConfig{StartPattern: `^\d{4}-\d{2}-\d{2}`, MaxLines: 3})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	joined := m.Aggregate([]LogEvent{
+		{ID: "1", Timestamp: 1, Message: "2024-01-01 ERROR request failed\n"},
+		{ID: "2", Timestamp: 2, Message: "java.lang.IllegalStateException: boom\n"},
+		{ID: "3", Timestamp: 3, Message: "\tat com.example.App.main(App.java:10)\n"},
+		{ID: "4", Timestamp: 4, Message: "\tat java.base/java.lang.Thread.run(Thread.java:833)\n"},
+		{ID: "5", Timestamp: 5, Message: "2024-01-01 INFO recovered"},
+	})
+
+	if len(joined) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %v", len(joined), joined)
+	}
+	expected := "2024-01-01 ERROR request failed\njava.lang.IllegalStateException: boom\n\tat com.example.App.main(App.java:10)"
+	if joined[0].Message != expected || joined[0].ID != "1" || joined[0].Timestamp != 1 {
+		t.Errorf("Expected joined first event, got %+v", joined[0])
+	}
+	if joined[1].ID != "4" {
+		t.Errorf("Expected max lines to start a new event, got %+v", joined[1])
+	}
+
+	m, _ = NewMultiline(MultilineConfig{ContinuationPattern: `^\s+at `, MaxBytes: 30})
+	joined = m.Aggregate([]LogEvent{
+		{Message: "Exception"},
+		{Message: "  at a.b(C.java:1)"},
+		{Message: "  at d.e(F.java:2)"},
+	})
+	if len(joined) != 2 || joined[0].Message != "Exception\n  at a.b(C.java:1)" {
+		t.Errorf("Expected max bytes to split events, got %v", joined)
+	}
+
+	if m, err := NewMultiline(MultilineConfig{}); m != nil || err != nil {
+		t.Errorf("Expected nil multiline without patterns, got %v (%v)", m, err)
+	}
+}
+
+func TestProvider_LoopGuard(t *testing.T) {
+	event := cloudWatchEvent(t, "a", "b")
+
+	for _, deny := range []string{"/aws/lambda/app", "/aws/lambda/*"} {
+		guard, err := NewLoopGuard(LoopGuardConfig{DenyLogGroups: []string{deny}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for _, extract := range []bool{true, false} {
+			provider := NewProviderWithOptions(Options{ExtractLogEvents: extract, LoopGuard: guard})
+			events, err := provider.ParseBatch(context.Background(), event)
+			if err != nil || len(events) != 0 {
+				t.Errorf("%s (extract %v): expected events to be dropped, got %d (%v)", deny, extract, len(events), err)
+			}
+		}
+	}
+
+	guard, _ := NewLoopGuard(LoopGuardConfig{DenyLogGroups: []string{"/aws/lambda/forwarder"}})
+	events, _ := NewProviderWithOptions(Options{ExtractLogEvents: true, LoopGuard: guard}).ParseBatch(context.Background(), event)
+	if len(events) != 2 {
+		t.Errorf("Expected other log groups to be forwarded, got %d events", len(events))
+	}
+}
+
+func TestLoopGuard_Breaker(t *testing.T) {
+	guard, err := NewLoopGuard(LoopGuardConfig{MaxEvents: 10, Window: time.Minute, Cooldown: 5 * time.Minute})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	guard.now = func() time.Time { return now }
+
+	if !guard.Allow("/aws/lambda/app", 6) || !guard.Allow("/aws/lambda/app", 4) {
+		t.Fatal("Expected events within the rate to be allowed")
+	}
+	if guard.Allow("/aws/lambda/app", 1) {
+		t.Error("Expected the breaker to open above the rate")
+	}
+	if !guard.Allow("/aws/lambda/other", 5) {
+		t.Error("Expected other log groups to be unaffected")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if guard.Allow("/aws/lambda/app", 1) {
+		t.Error("Expected the breaker to stay open during the cooldown")
+	}
+	now = now.Add(4 * time.Minute)
+	if !guard.Allow("/aws/lambda/app", 1) {
+		t.Error("Expected the breaker to close after the cooldown")
+	}
+
+	if g, err := NewLoopGuard(LoopGuardConfig{}); g != nil || err != nil {
+		t.Errorf("Expected nil guard without rules, got %v (%v)", g, err)
+	}
+	if _, err := NewLoopGuard(LoopGuardConfig{MaxEvents: 10}); err == nil {
+		t.Error("Expected an error for a breaker without a window")
+	}
+}
@@ -41,6 +41,13 @@ func (p *Provider) ParseEvent(ctx context.Context, rawEvent interface{}) (*model
 
 // ParseBatch parses a batch of GCP events
 func (p *Provider) ParseBatch(ctx context.Context, rawEvent interface{}) ([]*models.CloudEvent, error) {
+	ctx, span := provider.StartParseSpan(ctx, "gcp")
+	events, err := p.parseBatch(ctx, rawEvent)
+	provider.EndParseSpan(span, len(events), err)
+	return events, err
+}
+
+func (p *Provider) parseBatch(ctx context.Context, rawEvent interface{}) ([]*models.CloudEvent, error) {
 	event, err := p.ParseEvent(ctx, rawEvent)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/mosajjal/whatthehec/pkg/provider")
+
+// StartParseSpan starts the span covering the parsing of one invocation
+// payload by the named provider
+func StartParseSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "provider.parse",
+		trace.WithAttributes(attribute.String("cloud.provider", name)))
+}
+
+// EndParseSpan records the number of parsed events, or the error, and ends
+// the span
+func EndParseSpan(span trace.Span, events int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("whatthehec.events", events))
+	}
+	span.End()
+}
@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+// Schemes handled by the resolvers in this package
+const (
+	SchemeSecretsManager    = "aws-sm"
+	SchemeSecretsManagerARN = "arn:secretsmanager"
+	SchemeSSM               = "aws-ssm"
+	SchemeSSMARN            = "arn:ssm"
+)
+
+// SecretsManagerAPI is the subset of the Secrets Manager client used here
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SSMAPI is the subset of the SSM client used here
+type SSMAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SecretsManagerResolver resolves arn:aws:secretsmanager:... and
+// aws-sm://name references
+type SecretsManagerResolver struct {
+	client SecretsManagerAPI
+}
+
+// NewSecretsManagerResolver creates a Secrets Manager resolver
+func NewSecretsManagerResolver(client SecretsManagerAPI) *SecretsManagerResolver {
+	return &SecretsManagerResolver{client: client}
+}
+
+// Resolve fetches the secret string
+func (r *SecretsManagerResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secret.RequestTimeout)
+	defer cancel()
+	out, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(strings.TrimPrefix(ref, SchemeSecretsManager+"://")),
+	}, func(o *secretsmanager.Options) {
+		if region := arnRegion(ref); region != "" {
+			o.Region = region
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret from Secrets Manager: %w", err)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no string value", ref)
+	}
+	return *out.SecretString, nil
+}
+
+// SSMResolver resolves arn:aws:ssm:...:parameter/name and aws-ssm:///name
+// references. SecureString parameters are decrypted.
+type SSMResolver struct {
+	client SSMAPI
+}
+
+// NewSSMResolver creates a Parameter Store resolver
+func NewSSMResolver(client SSMAPI) *SSMResolver {
+	return &SSMResolver{client: client}
+}
+
+// Resolve fetches the parameter value
+func (r *SSMResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name := ref
+	if strings.HasPrefix(ref, SchemeSSM+"://") {
+		name = strings.TrimPrefix(ref, SchemeSSM+"://")
+		if !strings.HasPrefix(name, "/") && strings.Contains(name, "/") {
+			name = "/" + name
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, secret.RequestTimeout)
+	defer cancel()
+	out, err := r.client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	}, func(o *ssm.Options) {
+		if region := arnRegion(ref); region != "" {
+			o.Region = region
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get parameter from SSM: %w", err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return *out.Parameter.Value, nil
+}
+
+// arnRegion returns the region of an ARN reference so that secrets in other
+// regions can be read, or "" for other references
+func arnRegion(ref string) string {
+	parsed, err := arn.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return parsed.Region
+}
+
+// Register adds the Secrets Manager and SSM resolvers to registry
+func Register(registry *secret.Registry, cfg aws.Config) {
+	sm := NewSecretsManagerResolver(secretsmanager.NewFromConfig(cfg))
+	registry.Register(SchemeSecretsManager, sm)
+	registry.Register(SchemeSecretsManagerARN, sm)
+
+	ps := NewSSMResolver(ssm.NewFromConfig(cfg))
+	registry.Register(SchemeSSM, ps)
+	registry.Register(SchemeSSMARN, ps)
+}
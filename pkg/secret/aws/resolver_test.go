@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+type mockSecretsManager struct {
+	secretID string
+	region   string
+	value    string
+	deadline time.Time
+}
+
+func (m *mockSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	var o secretsmanager.Options
+	for _, fn := range optFns {
+		fn(&o)
+	}
+	m.secretID = *params.SecretId
+	m.region = o.Region
+	m.deadline, _ = ctx.Deadline()
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(m.value)}, nil
+}
+
+type mockSSM struct {
+	name     string
+	decrypt  bool
+	deadline time.Time
+}
+
+func (m *mockSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	m.name = *params.Name
+	m.decrypt = *params.WithDecryption
+	m.deadline, _ = ctx.Deadline()
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Value: aws.String("ssm-token")}}, nil
+}
+
+func TestSecretsManagerResolver(t *testing.T) {
+	sm := &mockSecretsManager{value: `{"hec_token": "json-token"}`}
+	registry := secret.NewRegistry()
+	resolver := NewSecretsManagerResolver(sm)
+	registry.Register(SchemeSecretsManager, resolver)
+	registry.Register(SchemeSecretsManagerARN, resolver)
+
+	arnRef := "arn:aws:secretsmanager:eu-west-1:123456789012:secret:hec-AbCdEf"
+	value, err := registry.Resolve(context.Background(), arnRef+"#hec_token")
+	if err != nil || value != "json-token" {
+		t.Errorf("Expected 'json-token', got '%s' (%v)", value, err)
+	}
+	if sm.secretID != arnRef {
+		t.Errorf("Expected secret ID '%s', got '%s'", arnRef, sm.secretID)
+	}
+	if sm.region != "eu-west-1" {
+		t.Errorf("Expected region from ARN, got '%s'", sm.region)
+	}
+
+	if _, err := registry.Resolve(context.Background(), "aws-sm://splunk/hec"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sm.secretID != "splunk/hec" || sm.region != "" {
+		t.Errorf("Expected secret 'splunk/hec' in default region, got '%s' in '%s'", sm.secretID, sm.region)
+	}
+}
+
+func TestSSMResolver(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"aws-ssm:///splunk/hec-token", "/splunk/hec-token"},
+		{"aws-ssm://splunk/hec-token", "/splunk/hec-token"},
+		{"aws-ssm://hec-token", "hec-token"},
+		{"arn:aws:ssm:us-east-1:123456789012:parameter/splunk/hec-token", "arn:aws:ssm:us-east-1:123456789012:parameter/splunk/hec-token"},
+	}
+
+	for _, tt := range tests {
+		client := &mockSSM{}
+		value, err := NewSSMResolver(client).Resolve(context.Background(), tt.ref)
+		if err != nil || value != "ssm-token" {
+			t.Errorf("%s: expected 'ssm-token', got '%s' (%v)", tt.ref, value, err)
+		}
+		if client.name != tt.expected {
+			t.Errorf("%s: expected parameter '%s', got '%s'", tt.ref, tt.expected, client.name)
+		}
+		if !client.decrypt {
+			t.Errorf("%s: expected decryption to be requested", tt.ref)
+		}
+	}
+}
+
+func TestSecretsManagerResolver_Timeout(t *testing.T) {
+	sm := &mockSecretsManager{value: "token"}
+	start := time.Now()
+	if _, err := NewSecretsManagerResolver(sm).Resolve(context.Background(), "aws-sm://splunk/hec"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sm.deadline.IsZero() || sm.deadline.After(start.Add(secret.RequestTimeout+time.Second)) {
+		t.Errorf("Expected a deadline within %v, got %v", secret.RequestTimeout, sm.deadline)
+	}
+}
+
+func TestSSMResolver_Timeout(t *testing.T) {
+	client := &mockSSM{}
+	start := time.Now()
+	if _, err := NewSSMResolver(client).Resolve(context.Background(), "aws-ssm:///splunk/hec-token"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if client.deadline.IsZero() || client.deadline.After(start.Add(secret.RequestTimeout+time.Second)) {
+		t.Errorf("Expected a deadline within %v, got %v", secret.RequestTimeout, client.deadline)
+	}
+}
@@ -0,0 +1,124 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+// Scheme is the reference scheme for Key Vault secrets:
+// azure-kv://<vault>/<secret>[/<version>]
+const Scheme = "azure-kv"
+
+const (
+	vaultResource   = "https://vault.azure.net"
+	vaultAPIVersion = "7.4"
+	imdsEndpoint    = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// KeyVaultResolver reads secrets from Azure Key Vault using the managed
+// identity of the Function App or VM
+type KeyVaultResolver struct {
+	// HTTPClient is used for all requests
+	HTTPClient *http.Client
+	// VaultURL overrides https://<vault>.vault.azure.net, mainly for tests
+	VaultURL string
+	// TokenFunc overrides managed identity token retrieval
+	TokenFunc func(ctx context.Context) (string, error)
+}
+
+// NewKeyVaultResolver creates a Key Vault resolver
+func NewKeyVaultResolver() *KeyVaultResolver {
+	return &KeyVaultResolver{HTTPClient: &http.Client{Timeout: secret.RequestTimeout}}
+}
+
+// Resolve fetches the secret value
+func (r *KeyVaultResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	vault, name, version, err := parseRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := r.token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed identity token: %w", err)
+	}
+
+	base := r.VaultURL
+	if base == "" {
+		base = fmt.Sprintf("https://%s.vault.azure.net", vault)
+		if strings.Contains(vault, ".") {
+			base = "https://" + vault
+		}
+	}
+	secretURL := fmt.Sprintf("%s/secrets/%s", base, url.PathEscape(name))
+	if version != "" {
+		secretURL += "/" + url.PathEscape(version)
+	}
+	secretURL += "?api-version=" + vaultAPIVersion
+
+	var out struct {
+		Value string `json:"value"`
+	}
+	if err := secret.GetJSON(ctx, r.HTTPClient, secretURL, map[string]string{"Authorization": "Bearer " + token}, &out); err != nil {
+		return "", fmt.Errorf("failed to get secret from Key Vault: %w", err)
+	}
+	return out.Value, nil
+}
+
+// token returns an access token for Key Vault. App Service and Functions
+// expose IDENTITY_ENDPOINT, elsewhere the instance metadata service is used.
+// AZURE_CLIENT_ID selects a user-assigned identity.
+func (r *KeyVaultResolver) token(ctx context.Context) (string, error) {
+	if r.TokenFunc != nil {
+		return r.TokenFunc(ctx)
+	}
+
+	params := url.Values{"resource": {vaultResource}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		params.Set("client_id", clientID)
+	}
+
+	var tokenURL string
+	var headers map[string]string
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		params.Set("api-version", "2019-08-01")
+		tokenURL = endpoint + "?" + params.Encode()
+		headers = map[string]string{"X-IDENTITY-HEADER": os.Getenv("IDENTITY_HEADER")}
+	} else {
+		params.Set("api-version", "2018-02-01")
+		tokenURL = imdsEndpoint + "?" + params.Encode()
+		headers = map[string]string{"Metadata": "true"}
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := secret.GetJSON(ctx, r.HTTPClient, tokenURL, headers, &out); err != nil {
+		return "", err
+	}
+	return out.AccessToken, nil
+}
+
+// parseRef splits azure-kv://vault/name[/version]
+func parseRef(ref string) (vault, name, version string, err error) {
+	rest := strings.TrimPrefix(ref, Scheme+"://")
+	parts := strings.Split(rest, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid Key Vault reference %q, expected %s://<vault>/<secret>[/<version>]", ref, Scheme)
+	}
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+	return parts[0], parts[1], version, nil
+}
+
+// Register adds the Key Vault resolver to registry
+func Register(registry *secret.Registry) {
+	registry.Register(Scheme, NewKeyVaultResolver())
+}
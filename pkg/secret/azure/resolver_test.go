@@ -0,0 +1,84 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+func TestParseRef(t *testing.T) {
+	vault, name, version, err := parseRef("azure-kv://myvault/hec-token/abc123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if vault != "myvault" || name != "hec-token" || version != "abc123" {
+		t.Errorf("Unexpected parse result %s %s %s", vault, name, version)
+	}
+
+	for _, ref := range []string{"azure-kv://myvault", "azure-kv://myvault/", "azure-kv://a/b/c/d"} {
+		if _, _, _, err := parseRef(ref); err == nil {
+			t.Errorf("Expected error for %s, got nil", ref)
+		}
+	}
+}
+
+func TestKeyVaultResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secrets/hec-token" || r.URL.Query().Get("api-version") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"value": "kv-token", "id": "https://myvault.vault.azure.net/secrets/hec-token/abc"}`))
+	}))
+	defer server.Close()
+
+	r := &KeyVaultResolver{
+		HTTPClient: server.Client(),
+		VaultURL:   server.URL,
+		TokenFunc: func(ctx context.Context) (string, error) {
+			return "access-token", nil
+		},
+	}
+
+	value, err := r.Resolve(context.Background(), "azure-kv://myvault/hec-token")
+	if err != nil || value != "kv-token" {
+		t.Errorf("Expected 'kv-token', got '%s' (%v)", value, err)
+	}
+
+	if _, err := r.Resolve(context.Background(), "azure-kv://myvault/missing"); err == nil {
+		t.Error("Expected error for missing secret, got nil")
+	}
+}
+
+func TestKeyVaultResolver_AppServiceIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IDENTITY-HEADER") != "identity-secret" || r.URL.Query().Get("resource") != vaultResource {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "access-token"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("IDENTITY_ENDPOINT", server.URL)
+	t.Setenv("IDENTITY_HEADER", "identity-secret")
+
+	r := &KeyVaultResolver{HTTPClient: server.Client()}
+	token, err := r.token(context.Background())
+	if err != nil || token != "access-token" {
+		t.Errorf("Expected 'access-token', got '%s' (%v)", token, err)
+	}
+}
+
+func TestNewKeyVaultResolver_Timeout(t *testing.T) {
+	if timeout := NewKeyVaultResolver().HTTPClient.Timeout; timeout != secret.RequestTimeout {
+		t.Errorf("Expected request timeout %v, got %v", secret.RequestTimeout, timeout)
+	}
+}
@@ -0,0 +1,102 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+// Scheme is the reference scheme for Secret Manager secrets:
+// gcp-sm://projects/<project>/secrets/<secret>[/versions/<version>]
+const Scheme = "gcp-sm"
+
+const (
+	secretManagerURL = "https://secretmanager.googleapis.com/v1"
+	metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// SecretManagerResolver reads secrets from GCP Secret Manager using the
+// service account of the function
+type SecretManagerResolver struct {
+	// HTTPClient is used for all requests
+	HTTPClient *http.Client
+	// BaseURL overrides the Secret Manager API URL, mainly for tests
+	BaseURL string
+	// TokenFunc overrides metadata server token retrieval
+	TokenFunc func(ctx context.Context) (string, error)
+}
+
+// NewSecretManagerResolver creates a Secret Manager resolver
+func NewSecretManagerResolver() *SecretManagerResolver {
+	return &SecretManagerResolver{HTTPClient: &http.Client{Timeout: secret.RequestTimeout}}
+}
+
+// Resolve fetches the secret payload
+func (r *SecretManagerResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name, err := parseRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := r.token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get service account token: %w", err)
+	}
+
+	base := r.BaseURL
+	if base == "" {
+		base = secretManagerURL
+	}
+
+	var out struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := secret.GetJSON(ctx, r.HTTPClient, base+"/"+name+":access", map[string]string{"Authorization": "Bearer " + token}, &out); err != nil {
+		return "", fmt.Errorf("failed to access secret in Secret Manager: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(out.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+func (r *SecretManagerResolver) token(ctx context.Context) (string, error) {
+	if r.TokenFunc != nil {
+		return r.TokenFunc(ctx)
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := secret.GetJSON(ctx, r.HTTPClient, metadataTokenURL, map[string]string{"Metadata-Flavor": "Google"}, &out); err != nil {
+		return "", err
+	}
+	return out.AccessToken, nil
+}
+
+// parseRef returns the secret version resource name, defaulting to the
+// latest version
+func parseRef(ref string) (string, error) {
+	name := strings.Trim(strings.TrimPrefix(ref, Scheme+"://"), "/")
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 && len(parts) != 6 || parts[0] != "projects" || parts[2] != "secrets" || len(parts) == 6 && parts[4] != "versions" {
+		return "", fmt.Errorf("invalid Secret Manager reference %q, expected %s://projects/<project>/secrets/<secret>[/versions/<version>]", ref, Scheme)
+	}
+	if len(parts) == 4 {
+		name += "/versions/latest"
+	}
+	return name, nil
+}
+
+// Register adds the Secret Manager resolver to registry
+func Register(registry *secret.Registry) {
+	registry.Register(Scheme, NewSecretManagerResolver())
+}
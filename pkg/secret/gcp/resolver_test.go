@@ -0,0 +1,73 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mosajjal/whatthehec/pkg/secret"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"gcp-sm://projects/my-project/secrets/hec-token", "projects/my-project/secrets/hec-token/versions/latest"},
+		{"gcp-sm://projects/my-project/secrets/hec-token/versions/3", "projects/my-project/secrets/hec-token/versions/3"},
+	}
+
+	for _, tt := range tests {
+		name, err := parseRef(tt.ref)
+		if err != nil || name != tt.expected {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", tt.ref, tt.expected, name, err)
+		}
+	}
+
+	for _, ref := range []string{"gcp-sm://hec-token", "gcp-sm://projects/p/keys/k", "gcp-sm://projects/p/secrets/s/aliases/a"} {
+		if _, err := parseRef(ref); err == nil {
+			t.Errorf("Expected error for %s, got nil", ref)
+		}
+	}
+}
+
+func TestSecretManagerResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/my-project/secrets/hec-token/versions/latest:access" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data := base64.StdEncoding.EncodeToString([]byte("sm-token"))
+		w.Write([]byte(`{"name": "projects/my-project/secrets/hec-token/versions/1", "payload": {"data": "` + data + `"}}`))
+	}))
+	defer server.Close()
+
+	r := &SecretManagerResolver{
+		HTTPClient: server.Client(),
+		BaseURL:    server.URL,
+		TokenFunc: func(ctx context.Context) (string, error) {
+			return "access-token", nil
+		},
+	}
+
+	value, err := r.Resolve(context.Background(), "gcp-sm://projects/my-project/secrets/hec-token")
+	if err != nil || value != "sm-token" {
+		t.Errorf("Expected 'sm-token', got '%s' (%v)", value, err)
+	}
+
+	if _, err := r.Resolve(context.Background(), "gcp-sm://projects/my-project/secrets/other"); err == nil {
+		t.Error("Expected error for missing secret, got nil")
+	}
+}
+
+func TestNewSecretManagerResolver_Timeout(t *testing.T) {
+	if timeout := NewSecretManagerResolver().HTTPClient.Timeout; timeout != secret.RequestTimeout {
+		t.Errorf("Expected request timeout %v, got %v", secret.RequestTimeout, timeout)
+	}
+}
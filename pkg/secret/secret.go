@@ -0,0 +1,201 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestTimeout bounds each request a resolver makes to a secret store or
+// metadata endpoint, so that a wrong endpoint fails the cold start instead
+// of hanging it
+const RequestTimeout = 10 * time.Second
+
+// SecretResolver fetches the value of a secret reference such as
+// arn:aws:secretsmanager:... or azure-kv://vault/name
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to the SecretResolver interface
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f(ctx, ref)
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// Registry dispatches references to resolvers by URI scheme. References
+// without a registered scheme are returned unchanged, so plain tokens keep
+// working. A #key fragment selects a field from a JSON object secret.
+type Registry struct {
+	mu        sync.RWMutex
+	resolvers map[string]SecretResolver
+}
+
+// NewRegistry creates a registry with the file and env resolvers registered
+func NewRegistry() *Registry {
+	r := &Registry{resolvers: make(map[string]SecretResolver)}
+	r.Register("file", SecretResolverFunc(resolveFile))
+	r.Register("env", SecretResolverFunc(resolveEnv))
+	return r
+}
+
+// Register adds a resolver for a scheme. ARNs use "arn:<service>" as their
+// scheme, for example "arn:secretsmanager".
+func (r *Registry) Register(scheme string, resolver SecretResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[scheme] = resolver
+}
+
+// Handles reports whether ref is a reference to a registered resolver
+func (r *Registry) Handles(ref string) bool {
+	_, ok := r.lookup(ref)
+	return ok
+}
+
+// Resolve returns the secret value for ref, or ref itself if it is not a
+// reference
+func (r *Registry) Resolve(ctx context.Context, ref string) (string, error) {
+	resolver, ok := r.lookup(ref)
+	if !ok {
+		return ref, nil
+	}
+
+	ref, key, _ := strings.Cut(ref, "#")
+	value, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return value, nil
+	}
+	return jsonKey(value, key)
+}
+
+func (r *Registry) lookup(ref string) (SecretResolver, bool) {
+	scheme := Scheme(ref)
+	if scheme == "" {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resolver, ok := r.resolvers[scheme]
+	return resolver, ok
+}
+
+// Scheme returns the scheme of a reference: the part before "://", or
+// "arn:<service>" for ARNs. It returns "" for plain values.
+func Scheme(ref string) string {
+	if strings.HasPrefix(ref, "arn:") {
+		parts := strings.SplitN(ref, ":", 4)
+		if len(parts) == 4 {
+			return "arn:" + parts[2]
+		}
+		return ""
+	}
+	if scheme, _, ok := strings.Cut(ref, "://"); ok {
+		return scheme
+	}
+	return ""
+}
+
+// jsonKey extracts a string field from a JSON object secret
+func jsonKey(value, key string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select key %q", key)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret", key)
+	}
+	s, ok := field.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q in secret is not a string", key)
+	}
+	return s, nil
+}
+
+// GetJSON performs a GET request with headers and decodes the JSON response
+// into out. It is shared by resolvers backed by REST APIs.
+func GetJSON(ctx context.Context, client *http.Client, rawURL string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// resolveFile reads file:///path, trimming surrounding whitespace
+func resolveFile(ctx context.Context, ref string) (string, error) {
+	path := strings.TrimPrefix(ref, "file://")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resolveEnv reads env://NAME
+func resolveEnv(ctx context.Context, ref string) (string, error) {
+	name := strings.TrimPrefix(ref, "env://")
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// Refresh resolves ref every interval and calls update whenever the value
+// differs from the last one seen, starting from current, until ctx is
+// cancelled. Failures are logged and the previous value is kept.
+func Refresh(ctx context.Context, resolver SecretResolver, ref, current string, interval time.Duration, update func(string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := current
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		value, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to refresh secret", "error", err)
+			continue
+		}
+		if value != last {
+			slog.InfoContext(ctx, "Secret value changed, updating")
+			last = value
+			update(value)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package secret
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheme(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"arn:aws:secretsmanager:us-east-1:123456789012:secret:hec-AbCdEf", "arn:secretsmanager"},
+		{"arn:aws-cn:ssm:cn-north-1:123456789012:parameter/hec", "arn:ssm"},
+		{"azure-kv://myvault/hec-token", "azure-kv"},
+		{"file:///run/secrets/hec", "file"},
+		{"12345678-1234-1234-1234-123456789012", ""},
+		{"arn:broken", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Scheme(tt.ref); got != tt.expected {
+			t.Errorf("Scheme(%q): expected '%s', got '%s'", tt.ref, tt.expected, got)
+		}
+	}
+}
+
+func TestRegistry_Literal(t *testing.T) {
+	r := NewRegistry()
+	token := "12345678-1234-1234-1234-123456789012"
+
+	if r.Handles(token) {
+		t.Error("Expected plain token not to be handled")
+	}
+	value, err := r.Resolve(context.Background(), token)
+	if err != nil || value != token {
+		t.Errorf("Expected token unchanged, got '%s' (%v)", value, err)
+	}
+}
+
+func TestRegistry_FileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_HEC_TOKEN", "env-token")
+
+	r := NewRegistry()
+	ctx := context.Background()
+
+	if value, err := r.Resolve(ctx, "file://"+path); err != nil || value != "file-token" {
+		t.Errorf("Expected 'file-token', got '%s' (%v)", value, err)
+	}
+	if value, err := r.Resolve(ctx, "env://TEST_HEC_TOKEN"); err != nil || value != "env-token" {
+		t.Errorf("Expected 'env-token', got '%s' (%v)", value, err)
+	}
+	if _, err := r.Resolve(ctx, "env://TEST_HEC_TOKEN_MISSING"); err == nil {
+		t.Error("Expected error for unset variable, got nil")
+	}
+}
+
+func TestRegistry_JSONKey(t *testing.T) {
+	r := NewRegistry()
+	var got string
+	r.Register("test", SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		got = ref
+		return `{"token": "json-token", "port": 8088}`, nil
+	}))
+	ctx := context.Background()
+
+	value, err := r.Resolve(ctx, "test://hec#token")
+	if err != nil || value != "json-token" {
+		t.Errorf("Expected 'json-token', got '%s' (%v)", value, err)
+	}
+	if got != "test://hec" {
+		t.Errorf("Expected fragment to be stripped, got '%s'", got)
+	}
+
+	if _, err := r.Resolve(ctx, "test://hec#missing"); err == nil {
+		t.Error("Expected error for missing key, got nil")
+	}
+	if _, err := r.Resolve(ctx, "test://hec#port"); err == nil {
+		t.Error("Expected error for non-string key, got nil")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	var mu sync.Mutex
+	value := "v1"
+	resolver := SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return value, nil
+	})
+
+	updates := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Refresh(ctx, resolver, "test://hec", "v1", 10*time.Millisecond, func(s string) {
+		updates <- s
+	})
+
+	mu.Lock()
+	value = "v2"
+	mu.Unlock()
+
+	select {
+	case got := <-updates:
+		if got != "v2" {
+			t.Errorf("Expected update to 'v2', got '%s'", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected rotated value to be picked up")
+	}
+
+	// Unchanged values do not trigger updates
+	select {
+	case got := <-updates:
+		t.Errorf("Expected no further updates, got '%s'", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
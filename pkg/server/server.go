@@ -0,0 +1,106 @@
+// Package server runs the HTTP server through which the Azure Functions
+// host and Cloud Run deliver invocations to the forwarder. Unlike Lambda,
+// neither runtime calls a Go function directly, so the process serves
+// invocations until it is told to stop.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+	"github.com/mosajjal/whatthehec/pkg/tracing"
+)
+
+// Config describes the invocation server of one entry point
+type Config struct {
+	Name        string       // used in log messages, e.g. "Azure Function"
+	Addr        string       // address invocations are served on
+	Handler     http.Handler // serves each invocation
+	MetricsAddr string       // serve /metrics here, "" for none
+	// ShutdownTimeout bounds letting running invocations finish and
+	// shutting Sender and Tracer down
+	ShutdownTimeout time.Duration
+	Sender          hec.Sender
+	Tracer          *tracing.Provider
+}
+
+// Addr returns the listen address for the port in the environment
+// variable env, 8080 if unset
+func Addr(env string) string {
+	port := os.Getenv(env)
+	if port == "" {
+		port = "8080"
+	}
+	return ":" + port
+}
+
+// Decode reads the JSON event of an invocation request, and returns it
+// with the request context carrying the caller's trace context
+func Decode(r *http.Request) (context.Context, interface{}, error) {
+	var event interface{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, nil, fmt.Errorf("invalid invocation payload: %w", err)
+	}
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return ctx, event, nil
+}
+
+// Run serves invocations, and metrics if configured, until ctx ends. It
+// then lets running invocations finish and shuts the sender and tracer
+// down, within ShutdownTimeout. It returns an error if the invocation
+// server fails.
+func Run(ctx context.Context, cfg Config) error {
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           cfg.Handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fmt.Errorf("invocation server stopped: %w", server.ListenAndServe())
+	}()
+	// Metrics are best effort and do not stop invocations
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Default.ListenAndServe(cfg.MetricsAddr); err != nil {
+				slog.Error("Metrics server stopped", "error", err)
+			}
+		}()
+	}
+	slog.Info(cfg.Name+" for Splunk HEC ready", "addr", cfg.Addr, "metrics_addr", cfg.MetricsAddr)
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+	shutdown(server, cfg)
+	return err
+}
+
+// shutdown lets running invocations finish, then delivers or stores what
+// the sender still holds
+func shutdown(server *http.Server, cfg Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Invocations still running at shutdown", "error", err)
+	}
+	if err := cfg.Sender.Shutdown(ctx); err != nil {
+		slog.Error("Events not delivered at shutdown", "error", err)
+	}
+	if err := cfg.Tracer.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to export traces", "error", err)
+	}
+	slog.Info(cfg.Name + " handler shut down")
+}
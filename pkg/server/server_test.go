@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mosajjal/whatthehec/pkg/hec"
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// mockSender records Shutdown calls
+type mockSender struct {
+	shutdown bool
+}
+
+func (m *mockSender) SendEvents(ctx context.Context, events []*models.Event) error { return nil }
+func (m *mockSender) Send(ctx context.Context, events []*models.Event) *hec.Result {
+	return &hec.Result{}
+}
+func (m *mockSender) Flush(ctx context.Context) error { return nil }
+func (m *mockSender) Shutdown(ctx context.Context) error {
+	m.shutdown = true
+	return nil
+}
+func (m *mockSender) Close() error { return nil }
+
+func TestRun_Shutdown(t *testing.T) {
+	sender := &mockSender{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, Config{
+			Name:            "Test",
+			Addr:            "127.0.0.1:0",
+			Handler:         http.NotFoundHandler(),
+			ShutdownTimeout: time.Second,
+			Sender:          sender,
+		})
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Run to return when ctx ends")
+	}
+	if !sender.shutdown {
+		t.Error("Expected the sender to be shut down")
+	}
+}
+
+func TestRun_ListenError(t *testing.T) {
+	sender := &mockSender{}
+	err := Run(context.Background(), Config{
+		Name:            "Test",
+		Addr:            "invalid address",
+		Handler:         http.NotFoundHandler(),
+		ShutdownTimeout: time.Second,
+		Sender:          sender,
+	})
+	if err == nil || !strings.Contains(err.Error(), "invocation server stopped") {
+		t.Errorf("Expected the listen error, got %v", err)
+	}
+	if !sender.shutdown {
+		t.Error("Expected the sender to be shut down")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	t.Setenv("TEST_PORT", "")
+	if addr := Addr("TEST_PORT"); addr != ":8080" {
+		t.Errorf("Expected :8080, got %s", addr)
+	}
+	t.Setenv("TEST_PORT", "3000")
+	if addr := Addr("TEST_PORT"); addr != ":3000" {
+		t.Errorf("Expected :3000, got %s", addr)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"data":{"a":1}}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, event, err := Decode(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := event.(map[string]interface{})["data"]; !ok {
+		t.Errorf("Expected the decoded event, got %v", event)
+	}
+	if id := trace.SpanContextFromContext(ctx).TraceID().String(); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the caller's trace ID, got %s", id)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`not json`))
+	if _, _, err := Decode(req); err == nil {
+		t.Error("Expected error for invalid JSON, got nil")
+	}
+}
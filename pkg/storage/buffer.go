@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// BufferConfig controls when a BufferedStorage flushes to its backend
+type BufferConfig struct {
+	MaxEvents   int           // flush after this many events, 0 for no limit
+	MaxBytes    int           // flush after this many payload bytes, 0 for no limit
+	MaxAge      time.Duration // flush events older than this, 0 to only flush on size or Flush
+	Concurrency int           // maximum parallel uploads, defaults to 4
+	// MaxRetainBytes is how many payload bytes the buffer may hold when
+	// failed uploads are put back, DefaultMaxRetainBytes if zero
+	MaxRetainBytes int
+}
+
+// DefaultMaxRetainBytes bounds the memory held by failed uploads
+const DefaultMaxRetainBytes = 64 * 1024 * 1024
+
+// BufferedStorage accumulates events and writes them to the wrapped backend
+// in larger objects. Uploads run in the background with bounded concurrency,
+// and events that fail to upload are put back in the buffer to be retried
+// with the next upload, as long as the buffer stays within MaxRetainBytes.
+// Batches beyond that are dropped and reported by the next Flush. Call
+// Flush or Shutdown before the process stops.
+type BufferedStorage struct {
+	backend StorageBackend
+	config  BufferConfig
+
+	mu     sync.Mutex
+	events []*models.Event
+	size   int
+	oldest time.Time
+	closed bool
+
+	sem      chan struct{}
+	inflight int
+	idle     chan struct{} // closed when no uploads are running
+	pending  atomic.Int64  // events in running uploads
+
+	errMu sync.Mutex
+	errs  []error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBufferedStorage wraps a backend with a size and age based buffer
+func NewBufferedStorage(backend StorageBackend, cfg BufferConfig) *BufferedStorage {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.MaxRetainBytes <= 0 {
+		cfg.MaxRetainBytes = DefaultMaxRetainBytes
+	}
+
+	b := &BufferedStorage{
+		backend: backend,
+		config:  cfg,
+		sem:     make(chan struct{}, cfg.Concurrency),
+		idle:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	close(b.idle)
+
+	if cfg.MaxAge > 0 {
+		go b.ageLoop()
+	} else {
+		close(b.done)
+	}
+
+	return b
+}
+
+// Store adds events to the buffer and starts an upload when a size limit
+// is reached. Upload errors are reported by the next Flush or Close.
+func (b *BufferedStorage) Store(ctx context.Context, events []*models.Event) error {
+	var batches [][]*models.Event
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("buffered storage is closed")
+	}
+	for _, event := range events {
+		if len(b.events) == 0 {
+			b.oldest = time.Now()
+		}
+		b.events = append(b.events, event)
+		b.size += eventSize(event)
+		if b.full() {
+			batches = append(batches, b.take())
+		}
+	}
+	if b.expired(time.Now()) {
+		batches = append(batches, b.take())
+	}
+	b.reserve(len(batches))
+	b.mu.Unlock()
+
+	for _, batch := range batches {
+		b.upload(batch)
+	}
+	return nil
+}
+
+// Flush uploads any buffered events and waits for all uploads to finish.
+// It returns the errors of every upload that failed since the last Flush.
+func (b *BufferedStorage) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	batch := b.take()
+	if len(batch) > 0 {
+		b.reserve(1)
+	}
+	idle := b.idle
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.upload(batch)
+	}
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for storage uploads of %d events: %w", b.pending.Load(), ctx.Err())
+	}
+
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+	err := errors.Join(b.errs...)
+	b.errs = nil
+	return err
+}
+
+// Close flushes the buffer and closes the wrapped backend
+func (b *BufferedStorage) Close() error {
+	return b.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting events, uploads the buffer and closes the
+// wrapped backend. If ctx ends first, the error reports how many events
+// were still being uploaded.
+func (b *BufferedStorage) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	if b.config.MaxAge > 0 {
+		close(b.stop)
+	}
+	<-b.done
+
+	err := b.Flush(ctx)
+
+	// Batches that failed to upload were put back in the buffer
+	b.mu.Lock()
+	if left := len(b.events); left > 0 {
+		err = errors.Join(err, fmt.Errorf("%d buffered events not uploaded", left))
+	}
+	b.mu.Unlock()
+	return errors.Join(err, b.backend.Close())
+}
+
+// ageLoop flushes the buffer once the oldest event exceeds MaxAge
+func (b *BufferedStorage) ageLoop() {
+	defer close(b.done)
+
+	interval := b.config.MaxAge / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			var batch []*models.Event
+			if b.expired(now) {
+				batch = b.take()
+				b.reserve(1)
+			}
+			b.mu.Unlock()
+
+			if len(batch) > 0 {
+				b.upload(batch)
+			}
+		}
+	}
+}
+
+// reserve counts uploads that are about to start, so that a concurrent
+// Flush waits for them. Callers must hold b.mu.
+func (b *BufferedStorage) reserve(n int) {
+	if n == 0 {
+		return
+	}
+	if b.inflight == 0 {
+		b.idle = make(chan struct{})
+	}
+	b.inflight += n
+}
+
+// release marks a reserved upload as finished
+func (b *BufferedStorage) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inflight--
+	if b.inflight == 0 {
+		close(b.idle)
+	}
+}
+
+// upload stores a reserved batch in the background, blocking while the
+// maximum number of uploads is already running
+func (b *BufferedStorage) upload(batch []*models.Event) {
+	b.sem <- struct{}{}
+	b.pending.Add(int64(len(batch)))
+	go func() {
+		defer func() {
+			b.pending.Add(-int64(len(batch)))
+			<-b.sem
+			b.release()
+		}()
+
+		// Uploads outlive the invocation that triggered them, so they are
+		// not tied to the caller's context
+		if err := b.backend.Store(context.Background(), batch); err != nil {
+			// Only the events the backend did not store are kept
+			failed := batch
+			var partial *PartialError
+			if errors.As(err, &partial) {
+				failed = partial.Failed
+			}
+			err = fmt.Errorf("failed to upload %d of %d buffered events: %w", len(failed), len(batch), err)
+			if b.requeue(failed) {
+				slog.Error("Failed to upload buffered events, keeping them for the next upload", "count", len(failed), "error", err)
+			} else {
+				bufferDropped.Add(float64(len(failed)))
+				slog.Error("Failed to upload buffered events, dropping them as the buffer is full", "count", len(failed), "error", err)
+				err = fmt.Errorf("dropped %d events, buffer holds at most %d bytes: %w", len(failed), b.config.MaxRetainBytes, err)
+			}
+			b.errMu.Lock()
+			b.errs = append(b.errs, err)
+			b.errMu.Unlock()
+		}
+	}()
+}
+
+// requeue puts events that failed to upload back at the front of the
+// buffer, unless that would exceed MaxRetainBytes. Their age starts again,
+// so the retry waits for MaxAge or a size limit. It reports whether the
+// events were kept.
+func (b *BufferedStorage) requeue(batch []*models.Event) bool {
+	size := 0
+	for _, event := range batch {
+		size += eventSize(event)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size+size > b.config.MaxRetainBytes {
+		return false
+	}
+	if len(b.events) == 0 {
+		b.oldest = time.Now()
+	}
+	b.events = append(batch, b.events...)
+	b.size += size
+	return true
+}
+
+// full reports whether a size limit is reached. Callers must hold b.mu.
+func (b *BufferedStorage) full() bool {
+	return (b.config.MaxEvents > 0 && len(b.events) >= b.config.MaxEvents) ||
+		(b.config.MaxBytes > 0 && b.size >= b.config.MaxBytes)
+}
+
+// expired reports whether the oldest event exceeds MaxAge. Callers must hold b.mu.
+func (b *BufferedStorage) expired(now time.Time) bool {
+	return b.config.MaxAge > 0 && len(b.events) > 0 && now.Sub(b.oldest) >= b.config.MaxAge
+}
+
+// take empties the buffer and returns its events. Callers must hold b.mu.
+func (b *BufferedStorage) take() []*models.Event {
+	batch := b.events
+	b.events = nil
+	b.size = 0
+	return batch
+}
+
+// eventSize estimates the stored size of an event payload
+func eventSize(event *models.Event) int {
+	switch v := event.Event.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return len(data)
+	}
+}
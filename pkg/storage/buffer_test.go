@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+// mockBackend records stored batches
+type mockBackend struct {
+	mu      sync.Mutex
+	batches [][]*models.Event
+	err     error
+	delay   time.Duration
+	active  int
+	peak    int
+	closed  bool
+}
+
+func (m *mockBackend) Store(ctx context.Context, events []*models.Event) error {
+	m.mu.Lock()
+	m.active++
+	if m.active > m.peak {
+		m.peak = m.active
+	}
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+	m.batches = append(m.batches, events)
+	return m.err
+}
+
+func (m *mockBackend) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockBackend) batchSizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sizes []int
+	for _, batch := range m.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func testEvents(n int) []*models.Event {
+	events := make([]*models.Event, n)
+	for i := range events {
+		events[i] = &models.Event{Event: "0123456789"}
+	}
+	return events
+}
+
+func TestBufferedStorage_MaxEvents(t *testing.T) {
+	backend := &mockBackend{}
+	b := NewBufferedStorage(backend, BufferConfig{MaxEvents: 3})
+	ctx := context.Background()
+
+	if err := b.Store(ctx, testEvents(7)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sizes := backend.batchSizes()
+	total := 0
+	for _, size := range sizes {
+		if size > 3 {
+			t.Errorf("Expected batches of at most 3 events, got %d", size)
+		}
+		total += size
+	}
+	if len(sizes) != 3 || total != 7 {
+		t.Errorf("Expected 7 events in 3 batches, got %v", sizes)
+	}
+}
+
+func TestBufferedStorage_MaxBytes(t *testing.T) {
+	backend := &mockBackend{}
+	b := NewBufferedStorage(backend, BufferConfig{MaxBytes: 25})
+	ctx := context.Background()
+
+	b.Store(ctx, testEvents(2))
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	b.Store(ctx, testEvents(3))
+	b.Flush(ctx)
+
+	sizes := backend.batchSizes()
+	if len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 3 {
+		t.Errorf("Expected batches [2 3], got %v", sizes)
+	}
+}
+
+func TestBufferedStorage_MaxAge(t *testing.T) {
+	backend := &mockBackend{}
+	b := NewBufferedStorage(backend, BufferConfig{MaxAge: 100 * time.Millisecond})
+	defer b.Close()
+
+	b.Store(context.Background(), testEvents(2))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.batchSizes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected buffer to be flushed after MaxAge")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBufferedStorage_Concurrency(t *testing.T) {
+	backend := &mockBackend{delay: 20 * time.Millisecond}
+	b := NewBufferedStorage(backend, BufferConfig{MaxEvents: 1, Concurrency: 2})
+
+	b.Store(context.Background(), testEvents(8))
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(backend.batchSizes()) != 8 {
+		t.Errorf("Expected 8 uploads, got %d", len(backend.batchSizes()))
+	}
+	if backend.peak > 2 {
+		t.Errorf("Expected at most 2 concurrent uploads, got %d", backend.peak)
+	}
+}
+
+func TestBufferedStorage_Errors(t *testing.T) {
+	backend := &mockBackend{err: errors.New("upload failed")}
+	b := NewBufferedStorage(backend, BufferConfig{})
+
+	b.Store(context.Background(), testEvents(2))
+	if err := b.Flush(context.Background()); err == nil {
+		t.Error("Expected upload error from Flush, got nil")
+	}
+
+	// The failed batch is kept and uploaded by the next Flush
+	backend.mu.Lock()
+	backend.err = nil
+	backend.mu.Unlock()
+	if err := b.Flush(context.Background()); err != nil {
+		t.Errorf("Expected no error on second Flush, got %v", err)
+	}
+	if sizes := backend.batchSizes(); len(sizes) != 2 || sizes[1] != 2 {
+		t.Errorf("Expected the failed batch to be uploaded again, got %v", sizes)
+	}
+}
+
+// partialBackend fails to store the first event of its first batch
+type partialBackend struct {
+	mockBackend
+	failed bool
+}
+
+func (p *partialBackend) Store(ctx context.Context, events []*models.Event) error {
+	p.mockBackend.Store(ctx, events)
+	if p.failed {
+		return nil
+	}
+	p.failed = true
+	return &PartialError{Failed: events[:1], Err: errors.New("upload failed")}
+}
+
+func TestBufferedStorage_PartialFailure(t *testing.T) {
+	backend := &partialBackend{}
+	b := NewBufferedStorage(backend, BufferConfig{})
+
+	b.Store(context.Background(), testEvents(3))
+	err := b.Flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Fatalf("Expected 1 of 3 events reported failed, got %v", err)
+	}
+
+	// Only the failed event is uploaded again
+	if err := b.Flush(context.Background()); err != nil {
+		t.Errorf("Expected no error on second Flush, got %v", err)
+	}
+	if sizes := backend.batchSizes(); len(sizes) != 2 || sizes[1] != 1 {
+		t.Errorf("Expected only the failed event to be uploaded again, got %v", sizes)
+	}
+}
+
+func TestBufferedStorage_MaxRetainBytes(t *testing.T) {
+	backend := &mockBackend{err: errors.New("upload failed")}
+	b := NewBufferedStorage(backend, BufferConfig{MaxRetainBytes: 25})
+
+	// Two events of 10 bytes fit, so the failed batch is kept
+	b.Store(context.Background(), testEvents(2))
+	if err := b.Flush(context.Background()); err == nil || strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("Expected the failed batch to be kept, got %v", err)
+	}
+
+	// With a third event they no longer fit and are dropped
+	b.Store(context.Background(), testEvents(1))
+	err := b.Flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "dropped 3 events") {
+		t.Fatalf("Expected 3 events to be reported dropped, got %v", err)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected nothing left in the buffer, got %v", err)
+	}
+}
+
+func TestBufferedStorage_ShutdownUnuploaded(t *testing.T) {
+	backend := &mockBackend{err: errors.New("upload failed")}
+	b := NewBufferedStorage(backend, BufferConfig{})
+
+	b.Store(context.Background(), testEvents(3))
+	err := b.Shutdown(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "3 buffered events not uploaded") {
+		t.Errorf("Expected the events left in the buffer to be reported, got %v", err)
+	}
+}
+
+func TestBufferedStorage_Close(t *testing.T) {
+	backend := &mockBackend{}
+	b := NewBufferedStorage(backend, BufferConfig{MaxEvents: 100, MaxAge: time.Hour})
+
+	b.Store(context.Background(), testEvents(5))
+	if err := b.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if sizes := backend.batchSizes(); len(sizes) != 1 || sizes[0] != 5 {
+		t.Errorf("Expected buffered events to be flushed on Close, got %v", sizes)
+	}
+	if !backend.closed {
+		t.Error("Expected backend to be closed")
+	}
+	if err := b.Store(context.Background(), testEvents(1)); err == nil {
+		t.Error("Expected error storing to closed buffer, got nil")
+	}
+}
+
+func TestBufferedStorage_ShutdownTimeout(t *testing.T) {
+	backend := &mockBackend{delay: 200 * time.Millisecond}
+	b := NewBufferedStorage(backend, BufferConfig{MaxEvents: 3})
+
+	b.Store(context.Background(), testEvents(3))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := b.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "3 events") {
+		t.Errorf("Expected error to report 3 pending events, got %v", err)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error on second Shutdown, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	backend := &mockBackend{}
+	if err := Shutdown(context.Background(), backend); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !backend.closed {
+		t.Error("Expected backend to be closed")
+	}
+	if err := Shutdown(context.Background(), nil); err != nil {
+		t.Errorf("Expected nil backend to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/mosajjal/Go-Splunk-HTTP/splunk/v2"
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+const (
+	// FormatRaw writes only the event payload, one per line
+	FormatRaw = "raw"
+	// FormatHEC writes complete HEC JSON envelopes, one per line
+	FormatHEC = "hec"
+	// FormatParquet writes a Parquet file with a fixed HEC schema
+	FormatParquet = "parquet"
+)
+
+// Encoder turns a batch of events into a single storage object
+type Encoder interface {
+	// Encode returns the encoded and compressed object body
+	Encode(events []*models.Event) ([]byte, error)
+
+	// Extension returns the object key suffix, e.g. ".json.gz"
+	Extension() string
+}
+
+// NewEncoder creates an Encoder for the configured format and compression
+func NewEncoder(cfg StorageConfig) (Encoder, error) {
+	compression := cfg.CompressionType
+	if compression == "" {
+		compression = CompressionGzip
+	}
+
+	switch cfg.Format {
+	case "", FormatRaw, FormatHEC:
+		if _, err := compressionExtension(compression); err != nil {
+			return nil, err
+		}
+		format := cfg.Format
+		if format == "" {
+			format = FormatRaw
+		}
+		return &lineEncoder{format: format, compression: compression}, nil
+	case FormatParquet:
+		return newParquetEncoder(compression)
+	default:
+		return nil, fmt.Errorf("unknown storage format: %s", cfg.Format)
+	}
+}
+
+// MarshalEvent encodes a single event according to the storage format.
+// The returned bytes do not include a trailing newline.
+func MarshalEvent(format string, event *models.Event) ([]byte, error) {
+	switch format {
+	case "", FormatRaw:
+		switch v := event.Event.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		default:
+			return json.Marshal(v)
+		}
+	case FormatHEC:
+		// Use the same envelope that is POSTed to HEC so objects can be replayed verbatim
+		return json.Marshal(&splunk.Event{
+			Time:       splunk.EventTime{Time: event.Time},
+			Host:       event.Host,
+			Source:     event.Source,
+			SourceType: event.SourceType,
+			Index:      event.Index,
+			Event:      event.Event,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage format: %s", format)
+	}
+}
+
+// lineEncoder writes one event per line in raw or HEC format
+type lineEncoder struct {
+	format      string
+	compression string
+}
+
+func (e *lineEncoder) Encode(events []*models.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newCompressWriter(&buf, e.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		eventData, err := MarshalEvent(e.format, event)
+		if err != nil {
+			slog.Warn("Failed to marshal event", "error", err)
+			continue
+		}
+
+		if _, err := w.Write(eventData); err != nil {
+			return nil, fmt.Errorf("failed to write event: %w", err)
+		}
+		if _, err := w.Write([]byte("\n")); err != nil {
+			return nil, fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to flush compressed data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *lineEncoder) Extension() string {
+	ext, _ := compressionExtension(e.compression)
+	return ".json" + ext
+}
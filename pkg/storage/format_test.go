@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestMarshalEvent_Raw(t *testing.T) {
+	event := &models.Event{
+		Time:  time.Unix(1700000000, 0),
+		Host:  "test-host",
+		Event: "test event data",
+	}
+
+	for _, format := range []string{"", FormatRaw} {
+		data, err := MarshalEvent(format, event)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(data) != "test event data" {
+			t.Errorf("Expected raw event data, got '%s'", string(data))
+		}
+	}
+
+	data, err := MarshalEvent(FormatRaw, &models.Event{Event: map[string]string{"key": "value"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(data) != `{"key":"value"}` {
+		t.Errorf("Expected JSON event data, got '%s'", string(data))
+	}
+}
+
+func TestMarshalEvent_HEC(t *testing.T) {
+	event := &models.Event{
+		Time:       time.Unix(1700000000, 123000000),
+		Host:       "test-host",
+		Source:     "test-source",
+		SourceType: "test-sourcetype",
+		Index:      "test-index",
+		Event:      "test event data",
+	}
+
+	data, err := MarshalEvent(FormatHEC, event)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"time":       1700000000.123,
+		"host":       "test-host",
+		"source":     "test-source",
+		"sourcetype": "test-sourcetype",
+		"index":      "test-index",
+		"event":      "test event data",
+	}
+	for key, want := range expected {
+		if envelope[key] != want {
+			t.Errorf("Expected %s to be '%v', got '%v'", key, want, envelope[key])
+		}
+	}
+}
+
+func TestMarshalEvent_UnknownFormat(t *testing.T) {
+	if _, err := MarshalEvent("xml", &models.Event{Event: "test"}); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+}
+
+func TestNewEncoder_Extensions(t *testing.T) {
+	tests := []struct {
+		format      string
+		compression string
+		expected    string
+	}{
+		{"", "", ".json.gz"},
+		{FormatRaw, CompressionGzip, ".json.gz"},
+		{FormatHEC, CompressionZstd, ".json.zst"},
+		{FormatHEC, CompressionNone, ".json"},
+		{FormatParquet, CompressionZstd, ".parquet"},
+	}
+
+	for _, tt := range tests {
+		encoder, err := NewEncoder(StorageConfig{Format: tt.format, CompressionType: tt.compression})
+		if err != nil {
+			t.Fatalf("Expected no error for %s/%s, got %v", tt.format, tt.compression, err)
+		}
+		if encoder.Extension() != tt.expected {
+			t.Errorf("Expected extension '%s', got '%s'", tt.expected, encoder.Extension())
+		}
+	}
+}
+
+func TestNewEncoder_Invalid(t *testing.T) {
+	if _, err := NewEncoder(StorageConfig{Format: "xml"}); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+	if _, err := NewEncoder(StorageConfig{CompressionType: "bzip2"}); err == nil {
+		t.Error("Expected error for unknown compression, got nil")
+	}
+	if _, err := NewEncoder(StorageConfig{Format: FormatParquet, CompressionType: "bzip2"}); err == nil {
+		t.Error("Expected error for unknown parquet compression, got nil")
+	}
+}
+
+func TestLineEncoder_Compression(t *testing.T) {
+	events := []*models.Event{
+		{Event: "first event"},
+		{Event: "second event"},
+	}
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		encoder, err := NewEncoder(StorageConfig{Format: FormatRaw, CompressionType: compression})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		body, err := encoder.Encode(events)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var r io.Reader = bytes.NewReader(body)
+		switch compression {
+		case CompressionGzip:
+			r, err = gzip.NewReader(r)
+		case CompressionZstd:
+			r, err = zstd.NewReader(r)
+		}
+		if err != nil {
+			t.Fatalf("Expected %s reader, got %v", compression, err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Expected no error reading %s data, got %v", compression, err)
+		}
+		if string(data) != "first event\nsecond event\n" {
+			t.Errorf("Expected decoded %s data to match, got '%s'", compression, string(data))
+		}
+	}
+}
+
+func TestParquetEncoder(t *testing.T) {
+	events := []*models.Event{
+		{
+			Time:       time.UnixMilli(1700000000123),
+			Host:       "test-host",
+			Source:     "test-source",
+			SourceType: "test-sourcetype",
+			Index:      "test-index",
+			Event:      map[string]string{"key": "value"},
+		},
+	}
+
+	encoder, err := NewEncoder(StorageConfig{Format: FormatParquet, CompressionType: CompressionZstd})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	body, err := encoder.Encode(events)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	rows, err := parquet.Read[ParquetRow](bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Expected valid parquet data, got %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+	if rows[0].Time != 1700000000123 {
+		t.Errorf("Expected time to be 1700000000123, got %d", rows[0].Time)
+	}
+	if rows[0].SourceType != "test-sourcetype" {
+		t.Errorf("Expected sourcetype to be 'test-sourcetype', got '%s'", rows[0].SourceType)
+	}
+	if rows[0].Event != `{"key":"value"}` {
+		t.Errorf("Expected event to be JSON, got '%s'", rows[0].Event)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+const (
+	// KeyTemplateDefault is the legacy YYYY/MM/DD/HH layout
+	KeyTemplateDefault = "{year}/{month}/{day}/{hour}"
+	// KeyTemplateHive is a Hive-style partition layout for Athena/Glue
+	KeyTemplateHive = "year={year}/month={month}/day={day}/hour={hour}"
+
+	// KeyTimeArrival partitions objects by the time they were stored
+	KeyTimeArrival = "arrival"
+	// KeyTimeEvent partitions objects by the event timestamp
+	KeyTimeEvent = "event"
+)
+
+// KeyTemplate renders the partition part of storage object keys.
+//
+// Templates are made of literal text and {placeholder} fields. Supported
+// placeholders are {year}, {month}, {day}, {hour}, {minute}, {index},
+// {sourcetype}, {source} and {host}; any other name is looked up in the
+// event Metadata (e.g. {loggroup}).
+type KeyTemplate struct {
+	parts      []keyPart
+	timeSource string
+	utc        bool
+}
+
+type keyPart struct {
+	literal string
+	field   string
+}
+
+// NewKeyTemplate parses the key template from the storage configuration
+func NewKeyTemplate(cfg StorageConfig) (*KeyTemplate, error) {
+	template := cfg.KeyTemplate
+	switch template {
+	case "":
+		template = KeyTemplateDefault
+	case "hive":
+		template = KeyTemplateHive
+	}
+
+	timeSource := cfg.KeyTimeSource
+	switch timeSource {
+	case "":
+		timeSource = KeyTimeArrival
+	case KeyTimeArrival, KeyTimeEvent:
+	default:
+		return nil, fmt.Errorf("unknown key time source: %s", cfg.KeyTimeSource)
+	}
+
+	parts, err := parseKeyTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyTemplate{
+		parts:      parts,
+		timeSource: timeSource,
+		utc:        cfg.KeyUTC,
+	}, nil
+}
+
+func parseKeyTemplate(template string) ([]keyPart, error) {
+	var parts []keyPart
+	rest := template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start == -1 {
+			parts = append(parts, keyPart{literal: rest})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, keyPart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unclosed placeholder in key template: %s", template)
+		}
+		field := rest[start+1 : start+end]
+		if field == "" || strings.ContainsAny(field, "{/") {
+			return nil, fmt.Errorf("invalid placeholder in key template: %s", template)
+		}
+		parts = append(parts, keyPart{field: field})
+		rest = rest[start+end+1:]
+	}
+	return parts, nil
+}
+
+// Time returns the time used for partitioning an event
+func (k *KeyTemplate) Time(event *models.Event, arrival time.Time) time.Time {
+	t := arrival
+	if k.timeSource == KeyTimeEvent && !event.Time.IsZero() {
+		t = event.Time
+	}
+	if k.utc {
+		t = t.UTC()
+	}
+	return t
+}
+
+// Partition renders the template for a single event
+func (k *KeyTemplate) Partition(event *models.Event, arrival time.Time) string {
+	t := k.Time(event, arrival)
+
+	var sb strings.Builder
+	for _, part := range k.parts {
+		if part.field == "" {
+			sb.WriteString(part.literal)
+			continue
+		}
+		switch part.field {
+		case "year":
+			fmt.Fprintf(&sb, "%d", t.Year())
+		case "month":
+			fmt.Fprintf(&sb, "%02d", t.Month())
+		case "day":
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case "hour":
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case "minute":
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		default:
+			sb.WriteString(keyValue(event.Field(part.field)))
+		}
+	}
+	return strings.Trim(sb.String(), "/")
+}
+
+// keyValue makes a field value safe to use as a single key segment
+func keyValue(v string) string {
+	v = strings.Trim(v, "/")
+	if v == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer("/", "_", " ", "_").Replace(v)
+}
+
+// Group splits events by rendered partition, keeping the order in which
+// partitions are first seen
+func (k *KeyTemplate) Group(events []*models.Event, arrival time.Time) ([]string, map[string][]*models.Event) {
+	var partitions []string
+	groups := make(map[string][]*models.Event)
+	for _, event := range events {
+		partition := k.Partition(event, arrival)
+		if _, ok := groups[partition]; !ok {
+			partitions = append(partitions, partition)
+		}
+		groups[partition] = append(groups[partition], event)
+	}
+	return partitions, groups
+}
+
+// ObjectKey joins the prefix, partition and a unique object name. The name
+// starts with the arrival time and its UTC offset, Z for UTC.
+func (k *KeyTemplate) ObjectKey(prefix, partition string, arrival time.Time, id, ext string) string {
+	if k.utc {
+		arrival = arrival.UTC()
+	}
+	name := fmt.Sprintf("%s-%s%s", arrival.Format("2006-01-02T15:04:05.000Z07:00"), id, ext)
+	return path.Join(strings.Trim(prefix, "/"), partition, name)
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+)
+
+func TestKeyTemplate_Default(t *testing.T) {
+	keys, err := NewKeyTemplate(StorageConfig{KeyUTC: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	arrival := time.Date(2026, 10, 5, 7, 30, 0, 0, time.UTC)
+	partition := keys.Partition(&models.Event{}, arrival)
+	if partition != "2026/10/05/07" {
+		t.Errorf("Expected partition '2026/10/05/07', got '%s'", partition)
+	}
+
+	key := keys.ObjectKey("/logs/", partition, arrival, "id", ".json.gz")
+	if key != "logs/2026/10/05/07/2026-10-05T07:30:00.000Z-id.json.gz" {
+		t.Errorf("Unexpected object key '%s'", key)
+	}
+
+	key = keys.ObjectKey("", partition, arrival, "id", ".json.gz")
+	if key != "2026/10/05/07/2026-10-05T07:30:00.000Z-id.json.gz" {
+		t.Errorf("Unexpected object key without prefix '%s'", key)
+	}
+
+	// Local times keep their offset rather than claiming to be UTC
+	local, err := NewKeyTemplate(StorageConfig{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sydney := arrival.In(time.FixedZone("AEDT", 11*60*60))
+	key = local.ObjectKey("", "p", sydney, "id", ".json.gz")
+	if key != "p/2026-10-05T18:30:00.000+11:00-id.json.gz" {
+		t.Errorf("Unexpected object key for local time '%s'", key)
+	}
+}
+
+func TestKeyTemplate_HiveEventTime(t *testing.T) {
+	keys, err := NewKeyTemplate(StorageConfig{
+		KeyTemplate:   "index={index}/" + KeyTemplateHive,
+		KeyTimeSource: KeyTimeEvent,
+		KeyUTC:        true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	arrival := time.Date(2026, 10, 5, 7, 30, 0, 0, time.UTC)
+	event := &models.Event{
+		Time:  time.Date(2026, 9, 30, 23, 59, 0, 0, time.FixedZone("AEST", 10*3600)),
+		Index: "security",
+	}
+	partition := keys.Partition(event, arrival)
+	if partition != "index=security/year=2026/month=09/day=30/hour=13" {
+		t.Errorf("Unexpected partition '%s'", partition)
+	}
+
+	// Events without a timestamp fall back to arrival time
+	partition = keys.Partition(&models.Event{Index: "security"}, arrival)
+	if partition != "index=security/year=2026/month=10/day=05/hour=07" {
+		t.Errorf("Unexpected partition for event without time '%s'", partition)
+	}
+}
+
+func TestKeyTemplate_Fields(t *testing.T) {
+	keys, err := NewKeyTemplate(StorageConfig{KeyTemplate: "{sourcetype}/{loggroup}/{missing}"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	event := &models.Event{
+		SourceType: "aws:cloudwatch",
+		Metadata:   map[string]string{"loggroup": "/aws/lambda/my function"},
+	}
+	partition := keys.Partition(event, time.Now())
+	if partition != "aws:cloudwatch/aws_lambda_my_function/unknown" {
+		t.Errorf("Unexpected partition '%s'", partition)
+	}
+}
+
+func TestKeyTemplate_Group(t *testing.T) {
+	keys, err := NewKeyTemplate(StorageConfig{KeyTemplate: "{index}"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := []*models.Event{
+		{Index: "b"},
+		{Index: "a"},
+		{Index: "b"},
+	}
+	partitions, groups := keys.Group(events, time.Now())
+	if len(partitions) != 2 || partitions[0] != "b" || partitions[1] != "a" {
+		t.Fatalf("Unexpected partitions %v", partitions)
+	}
+	if len(groups["b"]) != 2 || len(groups["a"]) != 1 {
+		t.Errorf("Unexpected group sizes: b=%d a=%d", len(groups["b"]), len(groups["a"]))
+	}
+}
+
+func TestKeyTemplate_Invalid(t *testing.T) {
+	invalid := []StorageConfig{
+		{KeyTemplate: "{year/{month}"},
+		{KeyTemplate: "{year"},
+		{KeyTemplate: "{}"},
+		{KeyTimeSource: "ingest"},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewKeyTemplate(cfg); err == nil {
+			t.Errorf("Expected error for %+v, got nil", cfg)
+		}
+	}
+}
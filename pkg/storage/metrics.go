@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/mosajjal/whatthehec/pkg/metrics"
+)
+
+// Storage metrics, registered in metrics.Default
+var (
+	writesTotal = metrics.Default.Counter("whatthehec_storage_writes_total",
+		"Objects written by storage backends, by backend and result (ok or error)", "backend", "result")
+	writeEvents = metrics.Default.Counter("whatthehec_storage_events_total",
+		"Events in objects written by storage backends, by backend", "backend")
+	writeBytes = metrics.Default.Counter("whatthehec_storage_bytes_total",
+		"Bytes of objects written by storage backends, by backend", "backend")
+	writeDuration = metrics.Default.Histogram("whatthehec_storage_write_duration_seconds",
+		"Object write latency, by backend", metrics.DefaultBuckets, "backend")
+	bufferDropped = metrics.Default.Counter("whatthehec_storage_buffer_dropped_events_total",
+		"Events dropped by storage buffers after a failed upload, as the buffer was full")
+)
+
+// ObserveWrite records one object write by a backend, started at start
+func ObserveWrite(backend string, events, bytes int, start time.Time, err error) {
+	writeDuration.Observe(time.Since(start).Seconds(), backend)
+	if err != nil {
+		writesTotal.Inc(backend, "error")
+		return
+	}
+	writesTotal.Inc(backend, "ok")
+	writeEvents.Add(float64(events), backend)
+	writeBytes.Add(float64(bytes), backend)
+}
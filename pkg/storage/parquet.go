@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// ParquetRow is the fixed schema used for Parquet storage objects
+type ParquetRow struct {
+	Time       int64  `parquet:"time,timestamp(millisecond)"`
+	Host       string `parquet:"host,optional"`
+	Source     string `parquet:"source,optional"`
+	SourceType string `parquet:"sourcetype,optional"`
+	Index      string `parquet:"index,optional"`
+	Event      string `parquet:"event"`
+}
+
+// parquetEncoder writes events as a single Parquet file. Compression is
+// applied per column rather than to the whole object.
+type parquetEncoder struct {
+	codec compress.Codec
+}
+
+func newParquetEncoder(compression string) (*parquetEncoder, error) {
+	var codec compress.Codec
+	switch compression {
+	case CompressionGzip:
+		codec = &parquet.Gzip
+	case CompressionZstd:
+		codec = &parquet.Zstd
+	case CompressionNone:
+		codec = &parquet.Uncompressed
+	default:
+		return nil, fmt.Errorf("unknown compression type: %s", compression)
+	}
+	return &parquetEncoder{codec: codec}, nil
+}
+
+func (e *parquetEncoder) Encode(events []*models.Event) ([]byte, error) {
+	rows := make([]ParquetRow, 0, len(events))
+	for _, event := range events {
+		eventData, err := MarshalEvent(FormatRaw, event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event: %w", err)
+		}
+		rows = append(rows, ParquetRow{
+			Time:       event.Time.UnixMilli(),
+			Host:       event.Host,
+			Source:     event.Source,
+			SourceType: event.SourceType,
+			Index:      event.Index,
+			Event:      string(eventData),
+		})
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[ParquetRow](&buf, parquet.Compression(e.codec))
+	if _, err := w.Write(rows); err != nil {
+		return nil, fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *parquetEncoder) Extension() string {
+	return ".parquet"
+}
@@ -11,8 +11,7 @@
 // either express or implied. See the License for the specific language governing
 // permissions and limitations under the License.
 
-// Package s3util contains utilities for working with S3
-package main
+package s3
 
 import (
 	"errors"
@@ -37,8 +36,10 @@ const (
 		"s3[.-](website[-.])?(accelerate\\.)?(dualstack[-.])?" + // S3 service name with optional features
 		"(([-a-z0-9]+)\\.)?" + // region name, optional for us-east-1
 		"amazonaws\\.com"
-	nonVpceUrlPatternBucketIdx = 2
-	nonVpceUrlPatternRegionIdx = 7
+	nonVpceUrlPatternBucketIdx     = 2
+	nonVpceUrlPatternAccelerateIdx = 4
+	nonVpceUrlPatternDualStackIdx  = 5
+	nonVpceUrlPatternRegionIdx     = 7
 
 	// cn- is a prefix for China region
 	ChinaRegionPrefix = "cn-"
@@ -56,6 +57,9 @@ type AmazonS3URL struct {
 	Bucket       string
 	Key          string
 	Region       string
+	IsVPCE       bool
+	IsDualStack  bool
+	IsAccelerate bool
 }
 
 // IsBucketAndKeyPresent checks the AmazonS3URL if it contains both bucket and key
@@ -100,12 +104,17 @@ func ParseAmazonS3URL(s3URL *url.URL) (output AmazonS3URL) {
 	}
 
 	output, err := parseBucketAndRegionFromHost(s3URL.Host, vpceUrlRegex, vpceUrlPatternBucketIdx, vpceUrlPatternRegionIdx)
-	if err != nil {
+	if err == nil {
+		output.IsVPCE = true
+	} else {
 		output, err = parseBucketAndRegionFromHost(s3URL.Host, nonVpceUrlRegex, nonVpceUrlPatternBucketIdx, nonVpceUrlPatternRegionIdx)
 		if err != nil {
 			output.IsValidS3URI = false
 			return
 		}
+		result := nonVpceUrlRegex.FindStringSubmatch(s3URL.Host)
+		output.IsAccelerate = result[nonVpceUrlPatternAccelerateIdx] != ""
+		output.IsDualStack = result[nonVpceUrlPatternDualStackIdx] != ""
 	}
 
 	output.IsPathStyle = output.Bucket == ""
@@ -169,6 +178,6 @@ func parseBucketAndRegionFromHost(host string, re *regexp.Regexp, bucketIdx, reg
 
 // String returns the string representation of the AmazonS3URL
 func (output AmazonS3URL) String() string {
-	return fmt.Sprintf("{Region: %s; Bucket: %s; Key: %s; IsValidS3URI: %v; IsPathStyle: %v}",
-		output.Region, output.Bucket, output.Key, output.IsValidS3URI, output.IsPathStyle)
+	return fmt.Sprintf("{Region: %s; Bucket: %s; Key: %s; IsValidS3URI: %v; IsPathStyle: %v; IsVPCE: %v; IsDualStack: %v; IsAccelerate: %v}",
+		output.Region, output.Bucket, output.Key, output.IsValidS3URI, output.IsPathStyle, output.IsVPCE, output.IsDualStack, output.IsAccelerate)
 }
@@ -2,17 +2,19 @@ package s3
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 	"github.com/mosajjal/whatthehec/pkg/models"
 	"github.com/mosajjal/whatthehec/pkg/storage"
@@ -22,109 +24,227 @@ import (
 type Storage struct {
 	config    storage.StorageConfig
 	client    *s3.Client
+	encoder   storage.Encoder
+	keys      *storage.KeyTemplate
 	bucket    string
 	keyPrefix string
 }
 
 // NewStorage creates a new S3 storage backend
 func NewStorage(cfg storage.StorageConfig, awsCfg aws.Config) (*Storage, error) {
-	client := s3.NewFromConfig(awsCfg)
+	encoder, err := storage.NewEncoder(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Parse bucket and prefix from URL
-	u, err := url.Parse(cfg.URL)
+	keys, err := storage.NewKeyTemplate(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("invalid S3 URL: %w", err)
+		return nil, err
 	}
 
-	bucket := ""
-	keyPrefix := ""
+	if err := ValidatePutOptions(cfg); err != nil {
+		return nil, err
+	}
 
-	// Parse bucket from hostname or path
-	if strings.Contains(u.Host, ".s3.") || strings.Contains(u.Host, ".s3-") {
-		// Virtual-hosted-style URL: bucket.s3.region.amazonaws.com
-		parts := strings.Split(u.Host, ".")
-		if len(parts) > 0 {
-			bucket = parts[0]
-		}
-		keyPrefix = strings.Trim(u.Path, "/")
-	} else {
-		// Path-style URL: s3.region.amazonaws.com/bucket
-		pathParts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
-		if len(pathParts) > 0 {
-			bucket = pathParts[0]
-		}
-		if len(pathParts) > 1 {
-			keyPrefix = pathParts[1]
-		}
+	// Parse bucket and prefix from URL
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL: %w", err)
 	}
 
+	bucket, keyPrefix, optFns := resolveURL(u, cfg)
 	if bucket == "" {
 		return nil, fmt.Errorf("could not parse bucket name from URL: %s", cfg.URL)
 	}
 
+	if cfg.Region != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Region = cfg.Region
+		})
+	}
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Credentials = credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")
+		})
+	}
+
+	client := s3.NewFromConfig(awsCfg, optFns...)
+
 	return &Storage{
 		config:    cfg,
 		client:    client,
+		encoder:   encoder,
+		keys:      keys,
 		bucket:    bucket,
 		keyPrefix: keyPrefix,
 	}, nil
 }
 
-// Store saves events to S3
-func (s *Storage) Store(ctx context.Context, events []*models.Event) error {
-	// Convert events to JSON and compress
-	var buf bytes.Buffer
-	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
-
-	for _, event := range events {
-		var eventData []byte
-		var err error
-
-		// Handle different event types
-		switch v := event.Event.(type) {
-		case string:
-			eventData = []byte(v)
-		case []byte:
-			eventData = v
-		default:
-			eventData, err = json.Marshal(v)
-			if err != nil {
-				log.Printf("Failed to marshal event: %v", err)
-				continue
-			}
+// resolveURL extracts the bucket and key prefix from the storage URL and
+// returns the client options needed to reach it, including the region of
+// regional hosts. AWS URLs (including VPC
+// interface endpoints, dual-stack and accelerate hosts) are detected with
+// ParseAmazonS3URL; anything else is treated as a path-style S3-compatible
+// endpoint such as MinIO.
+func resolveURL(u *url.URL, cfg storage.StorageConfig) (string, string, []func(*s3.Options)) {
+	var optFns []func(*s3.Options)
+
+	parsed := ParseAmazonS3URL(u)
+	if !parsed.IsValidS3URI {
+		parsed = parsePathStyle(u)
+		if cfg.Endpoint == "" {
+			endpoint := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+			optFns = append(optFns, func(o *s3.Options) {
+				o.BaseEndpoint = aws.String(endpoint)
+				o.UsePathStyle = true
+			})
 		}
-
-		if _, err := gz.Write(eventData); err != nil {
-			log.Printf("Failed to write to gzip: %v", err)
+	} else if parsed.IsVPCE {
+		// The SDK adds the bucket back to the host for virtual-hosted-style requests
+		host := strings.TrimPrefix(u.Host, parsed.Bucket+".")
+		if parsed.IsPathStyle {
+			host = u.Host
+		}
+		endpoint := fmt.Sprintf("%s://%s", u.Scheme, host)
+		usePathStyle := parsed.IsPathStyle
+		optFns = append(optFns, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = usePathStyle
+		})
+	} else {
+		if parsed.IsDualStack {
+			optFns = append(optFns, func(o *s3.Options) {
+				o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+			})
+		}
+		if parsed.IsAccelerate {
+			optFns = append(optFns, func(o *s3.Options) {
+				o.UseAccelerate = true
+			})
 		}
-		gz.Write([]byte("\n")) // Add newline between events
 	}
-	gz.Close()
 
-	// Generate key with timestamp and UUID
-	now := time.Now()
-	key := fmt.Sprintf("%s/%d/%02d/%02d/%02d/%s-%s.json.gz",
-		s.keyPrefix,
-		now.Year(),
-		now.Month(),
-		now.Day(),
-		now.Hour(),
-		now.Format("2006-01-02T15:04:05.000Z"),
-		uuid.New().String(),
-	)
-
-	// Upload to S3
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+	// Regional hosts name the bucket's region; global hosts leave it to
+	// the AWS config. An explicit region is applied later and wins.
+	if region := parsed.Region; parsed.IsValidS3URI && strings.Contains(u.Host, "."+region+".") {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+
+	if cfg.Endpoint != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = cfg.ForcePathStyle
+		})
+	}
+
+	return parsed.Bucket, strings.Trim(parsed.Key, "/"), optFns
+}
+
+// parsePathStyle reads bucket and key from the path of a non-AWS URL,
+// e.g. http://localhost:9000/bucket/prefix
+func parsePathStyle(u *url.URL) AmazonS3URL {
+	output := AmazonS3URL{IsPathStyle: true}
+	pathParts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	output.Bucket = pathParts[0]
+	if len(pathParts) > 1 {
+		output.Key = pathParts[1]
+	}
+	return output
+}
+
+// ValidatePutOptions checks the encryption, KMS key and storage class
+// settings of cfg before any object is written
+func ValidatePutOptions(cfg storage.StorageConfig) error {
+	sse := types.ServerSideEncryption(cfg.ServerSideEncryption)
+	if sse != "" && !slices.Contains(sse.Values(), sse) {
+		return fmt.Errorf("unknown server side encryption: %s", cfg.ServerSideEncryption)
+	}
+	if cfg.KMSKeyID != "" && sse == types.ServerSideEncryptionAes256 {
+		return fmt.Errorf("a KMS key requires aws:kms or aws:kms:dsse encryption, not %s", cfg.ServerSideEncryption)
+	}
+	if cfg.StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(cfg.StorageClass)) {
+		return fmt.Errorf("unknown storage class: %s", cfg.StorageClass)
+	}
+	return nil
+}
+
+// putObjectInput builds the PutObject request with the configured
+// encryption, storage class and tags
+func (s *Storage) putObjectInput(key string, body []byte) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
-		Body:   bytes.NewReader(buf.Bytes()),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+		Body:   bytes.NewReader(body),
 	}
 
-	log.Printf("Successfully stored %d events to S3: %s/%s", len(events), s.bucket, key)
-	return nil
+	sse := s.config.ServerSideEncryption
+	if sse == "" && s.config.KMSKeyID != "" {
+		sse = string(types.ServerSideEncryptionAwsKms)
+	}
+	if sse != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(sse)
+	}
+	if s.config.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.config.KMSKeyID)
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.config.StorageClass)
+	}
+	if len(s.config.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range s.config.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	return input
+}
+
+// Store saves events to S3, writing one object per key partition. If only
+// some partitions fail, the error is a *storage.PartialError with their
+// events.
+func (s *Storage) Store(ctx context.Context, events []*models.Event) error {
+	now := time.Now()
+	partitions, groups := s.keys.Group(events, now)
+
+	var errs []error
+	var failed []*models.Event
+	for _, partition := range partitions {
+		group := groups[partition]
+		body, err := s.encoder.Encode(group)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to encode events: %w", err))
+			failed = append(failed, group...)
+			continue
+		}
+
+		key := s.keys.ObjectKey(s.keyPrefix, partition, now, uuid.New().String(), s.encoder.Extension())
+
+		// Upload to S3
+		start := time.Now()
+		spanCtx, span := storage.StartWriteSpan(ctx, "s3", s.bucket+"/"+key, len(group), len(body))
+		_, err = s.client.PutObject(spanCtx, s.putObjectInput(key, body))
+		storage.EndWriteSpan(span, err)
+		storage.ObserveWrite("s3", len(group), len(body), start, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to upload to S3: %w", err))
+			failed = append(failed, group...)
+			continue
+		}
+
+		slog.DebugContext(ctx, "Stored events in S3", "count", len(group), "bucket", s.bucket, "key", key)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	// Objects of the other partitions were written and must not be again
+	if len(failed) < len(events) {
+		return &storage.PartialError{Failed: failed, Err: errors.Join(errs...)}
+	}
+	return errors.Join(errs...)
 }
 
 // Close cleans up resources
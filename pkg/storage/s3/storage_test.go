@@ -0,0 +1,201 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mosajjal/whatthehec/pkg/models"
+	"github.com/mosajjal/whatthehec/pkg/storage"
+)
+
+func TestParseAmazonS3URL(t *testing.T) {
+	tests := []struct {
+		url        string
+		bucket     string
+		key        string
+		region     string
+		vpce       bool
+		dualStack  bool
+		accelerate bool
+	}{
+		{"https://mybucket.s3.ap-southeast-2.amazonaws.com/logs/", "mybucket", "logs/", "ap-southeast-2", false, false, false},
+		{"https://s3.us-west-2.amazonaws.com/mybucket/logs", "mybucket", "logs", "us-west-2", false, false, false},
+		{"https://mybucket.s3.dualstack.eu-west-1.amazonaws.com/logs", "mybucket", "logs", "eu-west-1", false, true, false},
+		{"https://mybucket.s3-accelerate.amazonaws.com/logs", "mybucket", "logs", "us-east-1", false, false, true},
+		{"https://mybucket.bucket.vpce-0a1b2c3d-4e5f.s3.us-east-1.vpce.amazonaws.com/logs", "mybucket", "logs", "us-east-1", true, false, false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		parsed := ParseAmazonS3URL(u)
+		if !parsed.IsValidS3URI {
+			t.Errorf("Expected %s to be a valid S3 URL", tt.url)
+			continue
+		}
+		if parsed.Bucket != tt.bucket || parsed.Key != tt.key || parsed.Region != tt.region {
+			t.Errorf("Unexpected parse result for %s: %s", tt.url, parsed)
+		}
+		if parsed.IsVPCE != tt.vpce || parsed.IsDualStack != tt.dualStack || parsed.IsAccelerate != tt.accelerate {
+			t.Errorf("Unexpected endpoint flags for %s: %s", tt.url, parsed)
+		}
+	}
+}
+
+func resolveOptions(t *testing.T, rawURL string, cfg storage.StorageConfig) (string, string, s3.Options) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Expected valid URL, got %v", err)
+	}
+	bucket, keyPrefix, optFns := resolveURL(u, cfg)
+	client := s3.NewFromConfig(aws.Config{Region: "us-east-1"}, optFns...)
+	return bucket, keyPrefix, client.Options()
+}
+
+func TestResolveURL(t *testing.T) {
+	bucket, keyPrefix, opts := resolveOptions(t, "https://mybucket.s3.us-east-1.amazonaws.com/failed-logs/", storage.StorageConfig{})
+	if bucket != "mybucket" || keyPrefix != "failed-logs" {
+		t.Errorf("Expected mybucket/failed-logs, got %s/%s", bucket, keyPrefix)
+	}
+	if opts.BaseEndpoint != nil {
+		t.Errorf("Expected default endpoint, got %s", *opts.BaseEndpoint)
+	}
+
+	_, _, opts = resolveOptions(t, "https://mybucket.s3.eu-west-2.amazonaws.com/", storage.StorageConfig{})
+	if opts.Region != "eu-west-2" {
+		t.Errorf("Expected region eu-west-2 from the URL, got %s", opts.Region)
+	}
+
+	global, _ := url.Parse("https://mybucket.s3.amazonaws.com/")
+	_, _, optFns := resolveURL(global, storage.StorageConfig{})
+	if region := s3.NewFromConfig(aws.Config{Region: "eu-central-1"}, optFns...).Options().Region; region != "eu-central-1" {
+		t.Errorf("Expected region from the AWS config for a global URL, got %s", region)
+	}
+
+	_, _, opts = resolveOptions(t, "https://mybucket.s3.dualstack.us-east-1.amazonaws.com/", storage.StorageConfig{})
+	if opts.EndpointOptions.UseDualStackEndpoint != aws.DualStackEndpointStateEnabled {
+		t.Error("Expected dual-stack endpoint to be enabled")
+	}
+
+	bucket, _, opts = resolveOptions(t, "https://mybucket.bucket.vpce-0a1b2c3d-4e5f.s3.us-east-1.vpce.amazonaws.com/logs", storage.StorageConfig{})
+	if bucket != "mybucket" {
+		t.Errorf("Expected bucket mybucket, got %s", bucket)
+	}
+	if opts.BaseEndpoint == nil || *opts.BaseEndpoint != "https://bucket.vpce-0a1b2c3d-4e5f.s3.us-east-1.vpce.amazonaws.com" {
+		t.Errorf("Unexpected VPC endpoint: %v", opts.BaseEndpoint)
+	}
+
+	bucket, keyPrefix, opts = resolveOptions(t, "http://localhost:9000/mybucket/cold/", storage.StorageConfig{})
+	if bucket != "mybucket" || keyPrefix != "cold" {
+		t.Errorf("Expected mybucket/cold, got %s/%s", bucket, keyPrefix)
+	}
+	if opts.BaseEndpoint == nil || *opts.BaseEndpoint != "http://localhost:9000" || !opts.UsePathStyle {
+		t.Errorf("Expected path-style MinIO endpoint, got %v", opts.BaseEndpoint)
+	}
+
+	_, _, opts = resolveOptions(t, "https://mybucket.s3.us-east-1.amazonaws.com/", storage.StorageConfig{
+		Endpoint:       "http://minio:9000",
+		ForcePathStyle: true,
+	})
+	if opts.BaseEndpoint == nil || *opts.BaseEndpoint != "http://minio:9000" || !opts.UsePathStyle {
+		t.Errorf("Expected custom endpoint override, got %v", opts.BaseEndpoint)
+	}
+}
+
+func TestPutObjectInput(t *testing.T) {
+	s := &Storage{
+		bucket: "mybucket",
+		config: storage.StorageConfig{
+			KMSKeyID:     "arn:aws:kms:us-east-1:123456789012:key/abcd",
+			StorageClass: "GLACIER_IR",
+			Tags:         map[string]string{"team": "security", "data class": "logs"},
+		},
+	}
+
+	input := s.putObjectInput("key", []byte("body"))
+	if input.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+		t.Errorf("Expected aws:kms encryption, got '%s'", input.ServerSideEncryption)
+	}
+	if aws.ToString(input.SSEKMSKeyId) != "arn:aws:kms:us-east-1:123456789012:key/abcd" {
+		t.Errorf("Unexpected KMS key '%s'", aws.ToString(input.SSEKMSKeyId))
+	}
+	if input.StorageClass != types.StorageClassGlacierIr {
+		t.Errorf("Expected GLACIER_IR storage class, got '%s'", input.StorageClass)
+	}
+	if aws.ToString(input.Tagging) != "data+class=logs&team=security" {
+		t.Errorf("Unexpected tagging '%s'", aws.ToString(input.Tagging))
+	}
+
+	input = (&Storage{bucket: "mybucket"}).putObjectInput("key", []byte("body"))
+	if input.ServerSideEncryption != "" || input.StorageClass != "" || input.Tagging != nil {
+		t.Error("Expected no optional PutObject settings by default")
+	}
+}
+
+func TestValidatePutOptions(t *testing.T) {
+	if err := ValidatePutOptions(storage.StorageConfig{ServerSideEncryption: "aws:kms", StorageClass: "GLACIER_IR"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := ValidatePutOptions(storage.StorageConfig{ServerSideEncryption: "rot13"}); err == nil {
+		t.Error("Expected error for unknown encryption, got nil")
+	}
+	if err := ValidatePutOptions(storage.StorageConfig{StorageClass: "COLD"}); err == nil {
+		t.Error("Expected error for unknown storage class, got nil")
+	}
+	if err := ValidatePutOptions(storage.StorageConfig{ServerSideEncryption: "AES256", KMSKeyID: "alias/logs"}); err == nil {
+		t.Error("Expected error for a KMS key with AES256 encryption, got nil")
+	}
+	if err := ValidatePutOptions(storage.StorageConfig{KMSKeyID: "alias/logs"}); err != nil {
+		t.Errorf("Expected a KMS key alone to imply aws:kms, got %v", err)
+	}
+}
+
+func TestStore_PartialFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/bad/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s, err := NewStorage(storage.StorageConfig{
+		URL:         server.URL + "/mybucket/logs",
+		Region:      "us-east-1",
+		AccessKey:   "key",
+		SecretKey:   "secret",
+		KeyTemplate: "{index}",
+	}, aws.Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	events := []*models.Event{
+		{Index: "good", Event: "a"},
+		{Index: "bad", Event: "b"},
+		{Index: "good", Event: "c"},
+	}
+	var partial *storage.PartialError
+	if err := s.Store(context.Background(), events); !errors.As(err, &partial) {
+		t.Fatalf("Expected a partial error, got %v", err)
+	}
+	if len(partial.Failed) != 1 || partial.Failed[0] != events[1] {
+		t.Errorf("Expected only the event of the failed partition, got %v", partial.Failed)
+	}
+
+	// When every partition fails, there is nothing partial about it
+	err = s.Store(context.Background(), events[1:2])
+	if err == nil || errors.As(err, &partial) {
+		t.Errorf("Expected a plain error, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// Shutdowner is implemented by backends that can stop within a deadline
+type Shutdowner interface {
+	// Shutdown writes any buffered events and releases resources, giving
+	// up when ctx ends
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown writes any events buffered by backend and closes it, within
+// ctx. A nil backend is ignored.
+func Shutdown(ctx context.Context, backend StorageBackend) error {
+	if backend == nil {
+		return nil
+	}
+	if s, ok := backend.(Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	var err error
+	if f, ok := backend.(Flusher); ok {
+		err = f.Flush(ctx)
+	}
+	return errors.Join(err, backend.Close())
+}
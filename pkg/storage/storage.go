@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"github.com/mosajjal/whatthehec/pkg/models"
 )
 
@@ -14,6 +15,27 @@ type StorageBackend interface {
 	Close() error
 }
 
+// Flusher is implemented by backends that buffer writes
+type Flusher interface {
+	// Flush writes any buffered events and waits for pending uploads
+	Flush(ctx context.Context) error
+}
+
+// PartialError is returned by a backend that stored some events but not
+// others, so that only the failed events are written again
+type PartialError struct {
+	Failed []*models.Event // events that were not stored
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d events not stored: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
 // StorageConfig holds common storage configuration
 type StorageConfig struct {
 	Provider        string // s3, azure-blob, gcs
@@ -23,5 +45,17 @@ type StorageConfig struct {
 	Region          string
 	Bucket          string
 	PathPrefix      string
-	CompressionType string // gzip, none
+	CompressionType string // gzip, zstd, none
+	Format          string // raw, hec, parquet
+	KeyTemplate     string // e.g. "{year}/{month}/{day}/{hour}", "hive", "{index}/{sourcetype}/{year}"
+	KeyTimeSource   string // arrival, event
+	KeyUTC          bool
+
+	// S3 options
+	Endpoint             string // custom endpoint for MinIO or other S3-compatible stores
+	ForcePathStyle       bool   // use path-style requests with a custom Endpoint
+	ServerSideEncryption string // AES256, aws:kms, aws:kms:dsse
+	KMSKeyID             string // KMS key for aws:kms encryption
+	StorageClass         string // STANDARD, STANDARD_IA, GLACIER_IR, ...
+	Tags                 map[string]string
 }
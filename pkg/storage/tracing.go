@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/mosajjal/whatthehec/pkg/storage")
+
+// StartWriteSpan starts the span of one object write by a backend
+func StartWriteSpan(ctx context.Context, backend, key string, events, bytes int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "storage.write",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("whatthehec.storage.backend", backend),
+			attribute.String("whatthehec.storage.key", key),
+			attribute.Int("whatthehec.events", events),
+			attribute.Int("whatthehec.storage.bytes", bytes),
+		))
+}
+
+// EndWriteSpan records the error, if any, and ends the span
+func EndWriteSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
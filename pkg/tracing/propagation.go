@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lambdaTraceKey is the context key under which the Lambda runtime stores
+// the X-Ray trace header of an invocation
+const lambdaTraceKey = "x-amzn-trace-id"
+
+// LambdaContext returns ctx with the X-Ray trace of the current Lambda
+// invocation as the remote parent, or ctx unchanged outside Lambda
+func LambdaContext(ctx context.Context) context.Context {
+	header, _ := ctx.Value(lambdaTraceKey).(string)
+	if header == "" {
+		header = os.Getenv("_X_AMZN_TRACE_ID")
+	}
+	return XRayContext(ctx, header)
+}
+
+// XRayContext returns ctx with the trace in an X-Ray trace header, such as
+// "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+// as the remote parent. X-Ray trace IDs map directly to W3C trace IDs.
+// It returns ctx unchanged if the header is invalid.
+func XRayContext(ctx context.Context, header string) context.Context {
+	var root, parent string
+	sampled := false
+	for _, part := range strings.Split(header, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "Root":
+			root = value
+		case "Parent":
+			parent = value
+		case "Sampled":
+			sampled = value == "1"
+		}
+	}
+
+	// Root is "1-<8 hex digit epoch>-<24 hex digits>"
+	version, rest, _ := strings.Cut(root, "-")
+	epoch, unique, _ := strings.Cut(rest, "-")
+	if version != "1" || len(epoch) != 8 || len(unique) != 24 {
+		return ctx
+	}
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	if _, err := hex.Decode(traceID[:], []byte(epoch+unique)); err != nil {
+		return ctx
+	}
+	if len(parent) != 16 {
+		return ctx
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parent)); err != nil {
+		return ctx
+	}
+
+	cfg := trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, Remote: true}
+	if sampled {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	sc := trace.NewSpanContext(cfg)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// carrierPaths are where trace context attributes are found in decoded
+// invocation payloads: the payload itself, Pub/Sub push messages, and
+// Event Hub and Service Bus messages
+var carrierPaths = [][]string{
+	nil,
+	{"attributes"},
+	{"message", "attributes"},
+	{"properties"},
+	{"applicationProperties"},
+	{"metadata", "properties"},
+}
+
+// EventContext returns ctx with the W3C trace context carried by a decoded
+// JSON invocation payload as the remote parent. It reads the traceparent
+// attribute of Pub/Sub messages, including the googclient_traceparent
+// attribute set by the Pub/Sub client libraries, and the traceparent or
+// Diagnostic-Id property of Event Hub messages. It returns ctx unchanged
+// if the payload carries no trace context.
+func EventContext(ctx context.Context, event interface{}) context.Context {
+	payload, ok := event.(map[string]interface{})
+	if !ok {
+		return ctx
+	}
+	for _, path := range carrierPaths {
+		attrs := lookup(payload, path)
+		if attrs == nil {
+			continue
+		}
+		carrier := make(propagation.MapCarrier)
+		for key, value := range attrs {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			carrier[strings.TrimPrefix(strings.ToLower(key), "googclient_")] = s
+		}
+		// Event Hub SDKs set Diagnostic-Id in the traceparent format
+		if carrier["traceparent"] == "" {
+			carrier["traceparent"] = carrier["diagnostic-id"]
+		}
+		if carrier["traceparent"] == "" {
+			continue
+		}
+		extracted := otel.GetTextMapPropagator().Extract(ctx, carrier)
+		if trace.SpanContextFromContext(extracted).IsValid() {
+			return extracted
+		}
+	}
+	return ctx
+}
+
+// lookup returns the object at path, matching keys case-insensitively
+func lookup(payload map[string]interface{}, path []string) map[string]interface{} {
+	current := payload
+	for _, name := range path {
+		var next map[string]interface{}
+		for key, value := range current {
+			if strings.EqualFold(key, name) {
+				next, _ = value.(map[string]interface{})
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
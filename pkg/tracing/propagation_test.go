@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestXRayContext(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", true, true},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0;Lineage=a87bd80c:0", true, false},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793", false, false},
+		{"Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", false, false},
+		{"Root=1-5759e988-zz862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		sc := trace.SpanContextFromContext(XRayContext(context.Background(), tt.header))
+		if sc.IsValid() != tt.valid {
+			t.Errorf("%q: expected valid %v, got %v", tt.header, tt.valid, sc.IsValid())
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if got := sc.TraceID().String(); got != "5759e988bd862e3fe1be46a994272793" {
+			t.Errorf("%q: expected trace ID from Root, got %s", tt.header, got)
+		}
+		if got := sc.SpanID().String(); got != "53995c3f42cd8ad8" {
+			t.Errorf("%q: expected span ID from Parent, got %s", tt.header, got)
+		}
+		if sc.IsSampled() != tt.sampled || !sc.IsRemote() {
+			t.Errorf("%q: expected sampled %v and remote, got %v and %v", tt.header, tt.sampled, sc.IsSampled(), sc.IsRemote())
+		}
+	}
+}
+
+func TestLambdaContext(t *testing.T) {
+	t.Setenv("_X_AMZN_TRACE_ID", "")
+	header := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	ctx := context.WithValue(context.Background(), lambdaTraceKey, header)
+	if sc := trace.SpanContextFromContext(LambdaContext(ctx)); !sc.IsValid() {
+		t.Error("Expected trace from the invocation context")
+	}
+
+	t.Setenv("_X_AMZN_TRACE_ID", header)
+	if sc := trace.SpanContextFromContext(LambdaContext(context.Background())); !sc.IsValid() {
+		t.Error("Expected trace from _X_AMZN_TRACE_ID")
+	}
+}
+
+func TestEventContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name  string
+		event interface{}
+		valid bool
+	}{
+		{"pubsub", map[string]interface{}{
+			"message": map[string]interface{}{
+				"attributes": map[string]interface{}{"googclient_traceparent": traceparent},
+				"data":       "aGVsbG8=",
+			},
+			"subscription": "projects/p/subscriptions/s",
+		}, true},
+		{"eventhub", map[string]interface{}{
+			"Metadata": map[string]interface{}{
+				"Properties": map[string]interface{}{"Diagnostic-Id": traceparent},
+			},
+		}, true},
+		{"top level", map[string]interface{}{"traceparent": traceparent}, true},
+		{"invalid", map[string]interface{}{"traceparent": "00-invalid"}, false},
+		{"none", map[string]interface{}{"message": map[string]interface{}{"data": "aGVsbG8="}}, false},
+		{"not an object", []interface{}{traceparent}, false},
+	}
+
+	for _, tt := range tests {
+		sc := trace.SpanContextFromContext(EventContext(context.Background(), tt.event))
+		if sc.IsValid() != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, sc.IsValid())
+			continue
+		}
+		if tt.valid && sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: expected trace ID from traceparent, got %s", tt.name, sc.TraceID())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package tracing sets up OpenTelemetry tracing with export over OTLP/HTTP
+// and extracts the trace context of incoming invocations. The forwarder
+// packages create spans with the OpenTelemetry API only, so they record
+// nothing until Setup installs a tracer provider.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config holds tracing settings
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector URL, such as
+	// http://localhost:4318. When empty, the standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT variables are used, and tracing is
+	// disabled if they are not set either.
+	Endpoint string
+	// Headers are sent with every export request, e.g. for authentication
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute
+	ServiceName string
+	// SampleRatio is the share of traces sampled when the invocation has
+	// no sampled parent, from 0 to 1. A sampled parent, such as an active
+	// X-Ray trace, is always followed.
+	SampleRatio float64
+}
+
+// Enabled reports whether an exporter endpoint is configured
+func (c Config) Enabled() bool {
+	return c.Endpoint != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Provider exports the spans recorded after Setup. A nil Provider, returned
+// when tracing is disabled, does nothing.
+type Provider struct {
+	tp *sdktrace.TracerProvider
+}
+
+// Setup installs a global tracer provider exporting to the configured
+// collector, and the W3C trace context propagator. It returns a nil
+// Provider when tracing is not enabled.
+func Setup(ctx context.Context, cfg Config) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "whatthehec"
+	}
+	// Lambda passes an unsampled X-Ray parent unless active tracing is on,
+	// so unsampled parents do not disable sampling
+	ratio := sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(ratio,
+			sdktrace.WithRemoteParentNotSampled(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	return &Provider{tp: tp}, nil
+}
+
+// Flush exports the spans recorded so far. Call it before returning from a
+// function invocation, as spans left in memory are lost if the runtime
+// freezes the process.
+func (p *Provider) Flush(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.tp.ForceFlush(ctx)
+}
+
+// Shutdown flushes the remaining spans and stops the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.tp.Shutdown(ctx)
+}
+
+// StartInvocation starts the span of one function invocation, as a child of
+// the remote parent in ctx, if any
+func StartInvocation(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer("github.com/mosajjal/whatthehec/pkg/tracing").Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer))
+}